@@ -0,0 +1,56 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"log"
+
+	"github.com/cdiener/architeuthis/lib"
+	"github.com/spf13/cobra"
+)
+
+// camiCmd represents the cami command
+var camiCmd = &cobra.Command{
+	Use:   "cami [flags] FILE...",
+	Short: "Write CAMI taxonomic profiles.",
+	Long: `Writes the abundances of each sample as a profile in the CAMI/OPAL
+bioboxes format. The profiles of all samples are written into the same
+file, one after the other.
+
+Abundances are summed up on the ranks of the lineage format and reported as
+percentages of all classified reads in the sample.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		taxonomy := getProvider(cmd)
+		format := getFormat(cmd, taxonomy)
+		out, _ := cmd.Flags().GetString("out")
+
+		counts := readCounts(args, "new_est_reads")
+		lineages := lib.CountLineages(counts, taxonomy, format)
+		err := lib.WriteCAMI(counts, lineages, format, loadedTaxonomy(cmd, taxonomy)+"-taxonomy", out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Wrote %d profiles to %s.", len(counts.Samples), out)
+	},
+}
+
+func init() {
+	exportCmd.AddCommand(camiCmd)
+
+	camiCmd.Flags().StringP("out", "o", "profiles.cami", "The output file.")
+}
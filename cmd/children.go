@@ -0,0 +1,60 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// childrenCmd represents the taxonomy children command
+var childrenCmd = &cobra.Command{
+	Use:   "children [files...]",
+	Short: "List the children of taxon IDs.",
+	Long: `Lists the direct children of each taxon ID, or all descendants with
+'--recursive'. Every line contains the queried taxon ID and the taxon ID, rank
+and name of a child.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		taxonomy := getProvider(cmd)
+		recursive, _ := cmd.Flags().GetBool("recursive")
+
+		writer := bufio.NewWriter(os.Stdout)
+		defer writer.Flush()
+		for _, q := range readQueries(args) {
+			queue := taxonomy.Children(parseTaxid(q))
+			for len(queue) > 0 {
+				child := queue[0]
+				queue = queue[1:]
+				rank, _ := taxonomy.Rank(child)
+				name, _ := taxonomy.Name(child)
+				fmt.Fprintf(writer, "%s\t%d\t%s\t%s\n", q, child, rank, name)
+				if recursive {
+					queue = append(queue, taxonomy.Children(child)...)
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	taxonomyCmd.AddCommand(childrenCmd)
+
+	childrenCmd.Flags().BoolP("recursive", "r", false, "List all descendants instead of the direct children.")
+}
@@ -0,0 +1,68 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"log"
+
+	"github.com/cdiener/architeuthis/lib"
+	"github.com/spf13/cobra"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Convert abundances into formats of other tools.",
+	Long: `The export command converts Bracken output, merged Bracken tables,
+Kraken2 or KrakenUniq reports and mapping summaries into the formats used by
+other tools for profiling and visualization. Lineages are obtained from the
+taxonomy.
+
+Bracken files use the estimated reads (new_est_reads), reports the reads
+classified directly to each taxon and mapping summaries the reads of each
+classification.`,
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.PersistentFlags().String("data-dir", "", "The path to the taxonomy dumps.")
+	exportCmd.PersistentFlags().StringP("format", "f", "{K};{p};{c};{o};{f};{g};{s}", "The taxonomic ranks to export.")
+	exportCmd.PersistentFlags().Bool("fill-miss-rank", false, "Fill missing ranks with the name of the next higher rank.")
+}
+
+// readCounts reads and combines the counts from Bracken files, merged Bracken
+// tables, reports and mapping summaries.
+func readCounts(files []string, column string) *lib.Counts {
+	var all lib.Counts
+	for _, filename := range files {
+		filetype, _ := lib.GetFormat(filename)
+		if filetype != "bracken" && filetype != "bracken-merged" && filetype != "mapping" &&
+			!lib.IsReport(filetype) {
+			log.Fatalf("file %s is not Bracken output, a report or a mapping summary", filename)
+		}
+		counts, err := lib.ReadCounts(filename, filetype, column)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := all.Merge(counts); err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.Printf("Read counts for %d samples.", len(all.Samples))
+	return &all
+}
@@ -43,27 +43,9 @@ read may map to 100 different species but only one genus. in that case the speci
 assignment would be very ambiguous but the genus assignment would not be. We report
 the number of different classifications (multiplicity) and the shannon index (taking
 abundance of kmers into account as well).`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		version, ok := lib.HasTaxonkit()
-		if !ok {
-			log.Fatal("no taxonkit installation could be found :(")
-		} else {
-			log.Printf("Found taxonkit=%s.", version)
-		}
-
-		datadir, err := cmd.Flags().GetString("data-dir")
-		if err != nil {
-			log.Fatal(err)
-		}
-		if datadir == "" {
-			k2lib, err := cmd.Flags().GetString("db")
-			if err == nil && k2lib != "" {
-				datadir = k2lib + "/taxonomy"
-				log.Printf("Using the taxonomy from the Kraken2 database at `%s`.", k2lib)
-			}
-		} else {
-			log.Printf("Using the taxonomy from the Kraken2 database at `%s`.", datadir)
-		}
+		taxonomy := getProvider(cmd)
 		max_entropy, err := cmd.Flags().GetFloat64("max-entropy")
 		if err != nil {
 			log.Fatal(err)
@@ -76,14 +58,11 @@ abundance of kmers into account as well).`,
 		if err != nil {
 			log.Fatal(err)
 		}
-		format, err := cmd.Flags().GetString("format")
-		if err != nil {
-			log.Fatal(err)
-		}
+		format := getFormat(cmd, taxonomy)
 
 		filetype, named := lib.GetFormat(args[0])
-		if filetype != "kraken2" {
-			log.Fatal("read scoring requires a Kraken2 file.")
+		if !lib.IsClassification(filetype) {
+			log.Fatal("read scoring requires a Kraken2, Centrifuge or Kaiju file.")
 		}
 		if named {
 			log.Println("detected Kraken2 output with taxon names.")
@@ -91,7 +70,7 @@ abundance of kmers into account as well).`,
 
 		out, _ := cmd.Flags().GetString("out")
 
-		err = lib.FilterReads(args[0], out, datadir, format, named,
+		err = lib.FilterReads(args[0], out, taxonomy, format, named,
 			min_consistency, max_entropy, max_multiplicity)
 
 		if err != nil {
@@ -108,6 +87,7 @@ func init() {
 	filterCmd.Flags().Float64("max-entropy", 0.1, "Maximum entropy for kmer classifications at classified rank.")
 	filterCmd.Flags().Float64("min-consistency", 0.9, "Minimum consistency of the read classification.")
 	filterCmd.Flags().Uint32("max-multiplicity", 2, "Maximum number of alternative classifications on the classified rank.")
-	filterCmd.Flags().StringP("format", "f", "{K};{p};{c};{o};{f};{g};{s}", "The taxonomic ranks to connsider during scoring.")
+	filterCmd.Flags().StringP("format", "f", "{K};{p};{c};{o};{f};{g};{s}", "The taxonomic ranks to consider during scoring.")
+	filterCmd.Flags().Bool("fill-miss-rank", false, "Fill missing ranks with the name of the next higher rank.")
 
 }
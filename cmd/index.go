@@ -0,0 +1,65 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"log"
+
+	"github.com/cdiener/architeuthis/lib"
+	"github.com/spf13/cobra"
+)
+
+// indexCmd represents the taxonomy index command
+var indexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "Prebuild the taxonomy index for a lineage format.",
+	Long: `Reading a full taxonomy dump is slow, so architeuthis caches a compact
+binary index of the taxonomy for each lineage format. Indices are built on first
+use, but this command lets you prebuild them, for instance before running many
+samples in parallel.
+
+Indices are stored in '$ARCHITEUTHIS_CACHE' or the user cache directory and are
+rebuilt automatically whenever the taxonomy files change.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		taxonomy, ok := getProvider(cmd).(*lib.FileTaxonomy)
+		if !ok {
+			log.Fatal("indices can only be built for taxonomy files, not with taxonkit.")
+		}
+		format := getFormat(cmd, taxonomy).RankFormat()
+
+		checksum, err := taxonomy.Checksum()
+		if err != nil {
+			log.Fatalf("could not read the taxonomy: %v", err)
+		}
+		path := lib.IndexPath(checksum, format)
+		if path == "" {
+			log.Fatal("could not find a cache directory, please set $ARCHITEUTHIS_CACHE.")
+		}
+		idx, err := lib.OpenIndex(taxonomy, format)
+		if err != nil {
+			log.Fatalf("could not build the index: %v", err)
+		}
+		log.Printf("Index for %d taxa and format `%s` is at %s.", len(idx.Taxids), format, path)
+	},
+}
+
+func init() {
+	taxonomyCmd.AddCommand(indexCmd)
+
+	indexCmd.Flags().StringP("format", "f", "{K};{p};{c};{o};{f};{g};{s}", "The taxonomic ranks to consider during scoring.")
+}
@@ -18,8 +18,6 @@ package cmd
 
 import (
 	"log"
-	"path/filepath"
-	"strings"
 
 	"github.com/cdiener/architeuthis/lib"
 	"github.com/spf13/cobra"
@@ -32,16 +30,23 @@ var kmersCmd = &cobra.Command{
 	Long: `Summarizes all individual k-mer assignments for each classified taxon
 across reads. That is particularly helpful to check how unique you assignments are or
 to identify instances where one taxon can also be classified as another taxon.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		setSampleNames(cmd)
 		filetype, named := lib.GetFormat(args[0])
-		if filetype != "kraken2" {
-			log.Fatal("mapping summaries require a Kraken2 file")
+		if !lib.IsClassification(filetype) {
+			log.Fatal("mapping summaries require a Kraken2, Centrifuge or Kaiju file")
 		}
 		if named {
 			log.Println("detected Kraken2 output with taxon names.")
 		}
-		id := strings.Split(filepath.Base(args[0]), ".")[0]
-		kmap, err := lib.SummarizeKmers(args[0], named)
+		// Centrifuge reads with several hits are classified to their LCA.
+		var taxonomy lib.TaxonomyProvider
+		if filetype == "centrifuge" {
+			taxonomy = getProvider(cmd)
+		}
+		id := lib.SampleID(args[0])
+		kmap, err := lib.SummarizeKmers(args[0], named, taxonomy)
 		if err != nil {
 			log.Fatal("Failed to build the mapping hash.")
 		}
@@ -56,4 +61,6 @@ func init() {
 	mappingCmd.AddCommand(kmersCmd)
 
 	kmersCmd.Flags().String("out", "mapping_kmers.csv", "The output file (CSV format).")
+	kmersCmd.Flags().String("data-dir", "", "The path to the taxonomy dumps (only used for Centrifuge output).")
+	addSampleFlags(kmersCmd)
 }
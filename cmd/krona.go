@@ -0,0 +1,99 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/cdiener/architeuthis/lib"
+	"github.com/spf13/cobra"
+)
+
+// kronaCmd represents the krona command
+var kronaCmd = &cobra.Command{
+	Use:   "krona [flags] FILE...",
+	Short: "Write Krona charts.",
+	Long: `Writes the abundances as input for Krona.
+
+The default is Krona XML with one dataset for each sample, which can be
+converted into an interactive chart with 'ktImportXML'. With '--out-format text'
+the input of 'ktImportText' is written instead. This has one file per sample,
+where the sample ID is added to the output name if there are several samples.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		taxonomy := getProvider(cmd)
+		format := getFormat(cmd, taxonomy)
+		out_format := getOutFormat(cmd, "xml", "text")
+		out, _ := cmd.Flags().GetString("out")
+
+		counts := readCounts(args, "new_est_reads")
+		lineages := lib.CountLineages(counts, taxonomy, format)
+		root := lib.KronaTree(counts, lineages, format)
+
+		if out_format == "xml" {
+			file, err := lib.CreateFile(out)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := lib.WriteKronaXML(root, counts.Samples, file); err != nil {
+				log.Fatal(err)
+			}
+			if err := file.Close(); err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Wrote %d datasets to %s.", len(counts.Samples), out)
+			return
+		}
+		for j, s := range counts.Samples {
+			filename := out
+			if len(counts.Samples) > 1 {
+				filename = sampleFilename(out, s)
+			}
+			file, err := lib.CreateFile(filename)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = lib.WriteKronaText(root, j, file)
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Wrote sample %s to %s.", s, filename)
+		}
+	},
+}
+
+func init() {
+	exportCmd.AddCommand(kronaCmd)
+
+	kronaCmd.Flags().StringP("out", "o", "krona.xml", "The output file.")
+	kronaCmd.Flags().String("out-format", "xml", "The output format (xml or text).")
+}
+
+// sampleFilename adds a sample ID to a filename before its extensions.
+func sampleFilename(filename string, sample_id string) string {
+	dir, base := filepath.Split(filename)
+	name, ext, _ := strings.Cut(base, ".")
+	if ext != "" {
+		ext = "." + ext
+	}
+	return dir + name + "." + sample_id + ext
+}
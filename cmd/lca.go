@@ -0,0 +1,61 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/cdiener/architeuthis/lib"
+	"github.com/spf13/cobra"
+)
+
+// lcaCmd represents the taxonomy lca command
+var lcaCmd = &cobra.Command{
+	Use:   "lca [files...]",
+	Short: "Find the lowest common ancestor of taxon IDs.",
+	Long: `Finds the lowest common ancestor for each line of taxon IDs separated by
+spaces or commas. Every output line contains the input line and the taxon ID
+of the lowest common ancestor, which is empty if none of the taxa are known.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		taxonomy, ok := getProvider(cmd).(lib.Ancestry)
+		if !ok {
+			log.Fatal("the selected taxonomy backend does not support LCA queries.")
+		}
+
+		writer := bufio.NewWriter(os.Stdout)
+		defer writer.Flush()
+		for _, q := range readQueries(args) {
+			var taxids []int
+			for _, tid := range strings.FieldsFunc(q, func(r rune) bool { return r == ' ' || r == ',' || r == '\t' }) {
+				taxids = append(taxids, parseTaxid(tid))
+			}
+			lca := ""
+			if tid, ok := taxonomy.LCA(taxids...); ok {
+				lca = fmt.Sprint(tid)
+			}
+			fmt.Fprintf(writer, "%s\t%s\n", q, lca)
+		}
+	},
+}
+
+func init() {
+	taxonomyCmd.AddCommand(lcaCmd)
+}
@@ -19,10 +19,10 @@ package cmd
 import (
 	"encoding/csv"
 	"errors"
+	"fmt"
 	"io"
 	"log"
-	"os"
-	"strings"
+	"strconv"
 
 	"github.com/cdiener/architeuthis/lib"
 	"github.com/spf13/cobra"
@@ -39,32 +39,58 @@ var lineageCmd = &cobra.Command{
 	Use:   "lineage",
 	Short: "Add lineage information to Bracken output.",
 	Long: `Sometimes you would like to annotate taxonomy IDs with their full
-canonical lineage. This command helps with this.
+canonical lineage. This command helps with this. It works on Bracken output,
+mapping summaries and Kraken2 or KrakenUniq reports, which are converted to CSV.
 
-The 'lineage' command does require taxonkit and the databases to be installed to
-work.
+With '--out-format biom' Bracken output and reports are written as a BIOM 1.0
+table instead, with the lineages as the 'taxonomy' metadata of the taxa. Reports
+use the reads classified directly to each taxon.
+
+The 'lineage' command requires an NCBI taxonomy dump which is read from
+'--data-dir', the '--db' Kraken2 database, or '~/.taxonkit'.
 `,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		format, err := cmd.Flags().GetString("format")
-		if err != nil {
-			log.Fatal(err)
-		}
 		out, err := cmd.Flags().GetString("out")
 		if err != nil {
 			log.Fatal(err)
 		}
-		datadir, err := cmd.Flags().GetString("data-dir")
-		if err != nil {
-			log.Fatal(err)
-		}
+		taxonomy := getProvider(cmd)
+		format := getFormat(cmd, taxonomy)
 		filetype, lineage := lib.GetFormat(args[0])
 		if lineage {
 			log.Fatalf("file %s already contains lineage information", args[0])
 		}
+		if getOutFormat(cmd, "csv", "biom") == "biom" {
+			if filetype != "bracken" && filetype != "bracken-merged" && !lib.IsReport(filetype) {
+				log.Fatalf("BIOM tables are not supported for format %s", filetype)
+			}
+			counts, err := lib.ReadCounts(args[0], filetype, "new_est_reads")
+			if err != nil {
+				log.Fatal(err)
+			}
+			lineages := lib.CountLineages(counts, taxonomy, format)
+			if err := lib.WriteBiom(counts, lineages, out); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		if lib.IsReport(filetype) {
+			err = FoldInReportLineage(args[0], format, out, taxonomy)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
 		if filetype != "bracken" && filetype != "mapping" && filetype != "bracken-merged" {
-			log.Fatalf("file %s is not bracken or mapping summary format", args[0])
+			log.Fatalf("file %s is not bracken, report or mapping summary format", args[0])
+		}
+		input, cleanup, err := lib.Spool(args[0])
+		if err != nil {
+			log.Fatal(err)
 		}
-		err = FoldInLineage(args[0], filetype, format, out, datadir)
+		defer cleanup()
+		err = FoldInLineage(input, filetype, format, out, taxonomy)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -83,29 +109,22 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	lineageCmd.Flags().String("data-dir", "", "The path to the taxonomy dumps.")
-	lineageCmd.Flags().StringP("format", "f", "{K};{p};{c};{o};{f};{g};{s}", "The taxonomic ranks to connsider during scoring.")
+	lineageCmd.Flags().StringP("format", "f", "{K};{p};{c};{o};{f};{g};{s}", "The taxonomic ranks to consider during scoring.")
+	lineageCmd.Flags().Bool("fill-miss-rank", false, "Fill missing ranks with the name of the next higher rank.")
 	lineageCmd.Flags().StringP("out", "o", "annotated.csv", "The filename of the output CSV.")
+	lineageCmd.Flags().String("out-format", "csv", "The output format (csv or biom).")
 }
 
-func FoldInLineage(filename string, filetype string, format string, out string, data_dir string) error {
-	version, ok := lib.HasTaxonkit()
-	if !ok {
-		return errors.New("no taxonkit installation could be found :(")
-	} else {
-		log.Printf("Found taxonkit=%s.", version)
-	}
-
+func FoldInLineage(filename string, filetype string, format *lib.LineageFormat, out string, taxonomy lib.TaxonomyProvider) (err error) {
 	log.Printf("Mapping taxonomy IDs from %s.", filename)
 
-	infile, err := os.Open(filename)
+	infile, err := lib.OpenFile(filename)
 	if err != nil {
 		return err
 	}
+	defer func() { infile.Close() }()
 
-	reader := csv.NewReader(infile)
-	if filetype == "bracken" {
-		reader.Comma = '\t'
-	}
+	reader := newTableReader(infile, filetype)
 	header, err := reader.Read()
 	if err != nil {
 		return err
@@ -136,27 +155,39 @@ func FoldInLineage(filename string, filetype string, format string, out string,
 		taxids[record[idx]] = true
 	}
 
-	log.Printf("Will map %d unique taxids with taxonkit.", len(taxids))
-	lineages := lib.AddLineage(taxids, data_dir, format)
+	log.Printf("Will map %d unique taxids.", len(taxids))
+	lineages := lib.AddLineage(taxids, taxonomy, format)
 
 	log.Printf("Writing annotated data to %s.", out)
 
-	infile.Seek(0, io.SeekStart)
-	outfile, err := os.Create(out)
+	// Compressed files can not be rewound so the input is read again.
+	infile.Close()
+	infile, err = lib.OpenFile(filename)
+	if err != nil {
+		return err
+	}
+	reader = newTableReader(infile, filetype)
+	outfile, err := lib.CreateFile(out)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if cerr := outfile.Close(); err == nil {
+			err = cerr
+		}
+	}()
 
 	writer := csv.NewWriter(outfile)
 	header, err = reader.Read()
 	if err != nil {
 		return err
 	}
-	header = append(header, "lineage", "taxid_lineage")
+	header = append(header, "lineage", "taxid_lineage", "remapped_taxid")
 	err = writer.Write(header)
 	if err != nil {
 		return err
 	}
+	var remapped lib.RemapSummary
 	for {
 		record, err := reader.Read()
 		if err == io.EOF {
@@ -165,11 +196,109 @@ func FoldInLineage(filename string, filetype string, format string, out string,
 		if err != nil {
 			log.Fatal(err)
 		}
-		l := *lineages[record[idx]]
-		record = append(record, strings.Join(l.Names, ";"), strings.Join(l.Taxids, ";"))
+		l, ok := lineages[record[idx]]
+		if !ok {
+			return fmt.Errorf("no lineage found for taxon ID %s", record[idx])
+		}
+		remapped.Count(l, 1)
+		record = append(record, format.Join(l.Names), format.Join(l.Taxids), l.Taxid)
 		writer.Write(record)
 	}
 	writer.Flush()
+	remapped.Log("records")
 
 	return nil
 }
+
+// FoldInReportLineage converts a Kraken2 report to CSV and adds the lineage of
+// each taxon.
+func FoldInReportLineage(filename string, format *lib.LineageFormat, out string, taxonomy lib.TaxonomyProvider) (err error) {
+	log.Printf("Mapping taxonomy IDs from %s.", filename)
+	report, err := lib.ReadReport(filename)
+	if err != nil {
+		return err
+	}
+	taxa := report.Taxa()
+	taxids := make(map[string]bool, len(taxa))
+	for _, taxid := range taxa {
+		taxids[strconv.Itoa(taxid)] = true
+	}
+	log.Printf("Will map %d unique taxids.", len(taxids))
+	lineages := lib.AddLineage(taxids, taxonomy, format)
+
+	log.Printf("Writing annotated data to %s.", out)
+	outfile, err := lib.CreateFile(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := outfile.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	writer := csv.NewWriter(outfile)
+	header := []string{"percentage", "clade_reads", "direct_reads"}
+	if report.Minimizers != nil {
+		header = append(header, "minimizers", "distinct_minimizers")
+	}
+	if report.Kmers != nil {
+		header = append(header, "kmers", "dup", "cov")
+	}
+	header = append(header, "rank_code", "taxid", "name", "rank",
+		"lineage", "taxid_lineage", "remapped_taxid")
+	err = writer.Write(header)
+	if err != nil {
+		return err
+	}
+
+	total := report.Total()
+	if report.Unclassified > 0 {
+		record := []string{
+			strconv.FormatFloat(100*report.Unclassified/total, 'f', 2, 64),
+			lib.FormatValue(report.Unclassified), lib.FormatValue(report.Unclassified),
+		}
+		if report.Minimizers != nil {
+			record = append(record, "0", "0")
+		}
+		if report.Kmers != nil {
+			record = append(record, "0", "0", "NA")
+		}
+		record = append(record, "U", "0", "unclassified", "", "", "", "")
+		writer.Write(record)
+	}
+	var remapped lib.RemapSummary
+	for _, taxid := range taxa {
+		node := report.Tree.Taxids[taxid]
+		record := []string{
+			strconv.FormatFloat(100*node.Value/total, 'f', 2, 64),
+			lib.FormatValue(node.Value), lib.FormatValue(report.Direct[taxid]),
+		}
+		if report.Minimizers != nil {
+			record = append(record,
+				strconv.FormatUint(report.Minimizers[taxid], 10),
+				strconv.FormatUint(report.DistinctMinimizers[taxid], 10))
+		}
+		if report.Kmers != nil {
+			record = append(record, lib.FormatValue(report.Kmers[taxid]),
+				lib.FormatValue(report.Duplication[taxid]), lib.FormatValue(report.Coverage[taxid]))
+		}
+		l := lineages[strconv.Itoa(taxid)]
+		remapped.Count(l, 1)
+		record = append(record, report.Codes[taxid], strconv.Itoa(taxid), node.Name,
+			node.Rank, format.Join(l.Names), format.Join(l.Taxids), l.Taxid)
+		writer.Write(record)
+	}
+	writer.Flush()
+	remapped.Log("records")
+
+	return writer.Error()
+}
+
+func newTableReader(file io.Reader, filetype string) *csv.Reader {
+	reader := csv.NewReader(file)
+	if filetype == "bracken" {
+		reader.Comma = '\t'
+	}
+	return reader
+}
@@ -25,10 +25,14 @@ import (
 )
 
 var HasHeader = map[string]bool{
-	"bracken": true,
-	"kraken2": false,
-	"mapping": true,
-	"report":  false,
+	"bracken":           true,
+	"kraken2":           false,
+	"centrifuge":        true,
+	"kaiju":             false,
+	"mapping":           true,
+	"report":            false,
+	"krakenuniq-report": false,
+	"mpa":               true,
 }
 
 // mergeCmd represents the merge command
@@ -37,15 +41,37 @@ var mergeCmd = &cobra.Command{
 	Short: "Merge various output files related to Kraken.",
 	Long: `This quickly merges Kraken output files across several samples.
 
-Supported formats are Bracken output and mapping summaries.`,
+Supported formats are Kraken2 and KrakenUniq output and reports, Bracken output,
+mapping summaries and MetaPhlAn-style (mpa) tables.
+
+Bracken files and merged Bracken tables can be merged together, where merged
+tables keep their sample IDs. Columns are combined by name, so files from
+different Bracken versions or with lineages can be merged. Columns missing from
+a file are left blank and reported.
+
+Kraken2 and KrakenUniq reports are merged into a long table with one row for each sample and
+taxon. With '--wide' they are merged into a matrix with one row for each taxon
+and one column for each sample instead. '--column' selects the values of the
+matrix (clade_reads, direct_reads, percentage or kmers for KrakenUniq).
+
+Bracken files and merged Bracken tables can also be merged into a matrix with
+'--wide'. Here '--column' selects the Bracken column and defaults to
+new_est_reads. '--lineage' adds the lineage of each taxon.
+
+Bracken files can be written as a BIOM 1.0 table with '--out-format biom'. The
+table has one row for each taxon with its lineage as the 'taxonomy' metadata and
+one column for each sample. '--column' selects the Bracken column for the values
+and defaults to new_est_reads.
+
+mpa tables are combined into one table with a column for each sample like
+'combine_mpa.py'. Clades missing from a sample are set to zero.`,
+	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		setSampleNames(cmd)
 		out, err := cmd.Flags().GetString("out")
 		if err != nil {
 			log.Fatal("Error in reading the output filename.")
 		}
-		if len(args) < 1 {
-			log.Fatal("Need at least 2 files to merge.")
-		}
 		formats := make([]string, len(args))
 		for i, fname := range args {
 			f, _ := lib.GetFormat(fname)
@@ -54,7 +80,12 @@ Supported formats are Bracken output and mapping summaries.`,
 			}
 			formats[i] = f
 		}
+		slices.Sort(formats)
 		formats = slices.Compact(formats)
+		if slices.Equal(formats, []string{"bracken", "bracken-merged"}) {
+			log.Println("Merging Bracken files with merged Bracken tables.")
+			formats = []string{"bracken"}
+		}
 		if len(formats) > 1 {
 			log.Fatalf("arguments have differing formats, found the following: %v", formats)
 		}
@@ -62,14 +93,37 @@ Supported formats are Bracken output and mapping summaries.`,
 
 		log.Printf("Detected format for files is '%s'.", format)
 
-		if format == "report" {
-			log.Fatalf("merging kraken2 report files is not supported")
+		wide, _ := cmd.Flags().GetBool("wide")
+		column, _ := cmd.Flags().GetString("column")
+		if wide && !lib.IsReport(format) && format != "bracken" && format != "bracken-merged" {
+			log.Fatalf("wide tables are not supported for format %s", format)
+		}
+		out_format := getOutFormat(cmd, "csv", "biom")
+		if out_format == "biom" && format != "bracken" && format != "bracken-merged" {
+			log.Fatalf("BIOM tables are not supported for format %s", format)
 		}
 
-		if format == "kraken2" || format == "mapping" || format == "bracken-merged" {
+		if out_format == "biom" {
+			if !cmd.Flags().Changed("column") {
+				column = "new_est_reads"
+			}
+			taxonomy := getProvider(cmd)
+			err = mergeBiom(args, out, column, taxonomy, getFormat(cmd, taxonomy))
+		} else if wide && !lib.IsReport(format) {
+			if !cmd.Flags().Changed("column") {
+				column = "new_est_reads"
+			}
+			pivotBracken(cmd, args, out, column)
+		} else if lib.IsReport(format) && wide {
+			err = lib.MergeReportsWide(args, out, column)
+		} else if lib.IsReport(format) {
+			err = lib.MergeReports(args, out)
+		} else if lib.IsClassification(format) || format == "mapping" {
 			err = lib.SimpleAppend(args, out, HasHeader[format])
-		} else if format == "bracken" {
-			err = lib.SampleAppend(args, out, '\t')
+		} else if format == "bracken" || format == "bracken-merged" {
+			err = lib.MergeBracken(args, out)
+		} else if format == "mpa" {
+			err = lib.MergeMPA(args, out)
 		} else {
 			log.Fatalf("I do no know how to merge format %s :(", format)
 		}
@@ -91,4 +145,43 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	mergeCmd.Flags().StringP("out", "o", "merged.csv", "The output filename.")
+	mergeCmd.Flags().Bool("wide", false, "Write a matrix with one column per sample.")
+	mergeCmd.Flags().StringP("column", "c", "clade_reads", "The values used in wide tables.")
+	mergeCmd.Flags().Bool("lineage", false, "Add lineage columns to wide Bracken tables.")
+	mergeCmd.Flags().String("out-format", "csv", "The output format (csv or biom).")
+	mergeCmd.Flags().String("data-dir", "", "The path to the taxonomy dumps (only used for lineages).")
+	mergeCmd.Flags().StringP("format", "f", "{K};{p};{c};{o};{f};{g};{s}", "The taxonomic ranks used for lineages.")
+	mergeCmd.Flags().Bool("fill-miss-rank", false, "Fill missing ranks with the name of the next higher rank.")
+	addSampleFlags(mergeCmd)
+}
+
+// mergeBiom merges Bracken files into a BIOM table.
+func mergeBiom(files []string, out string, column string, taxonomy lib.TaxonomyProvider,
+	format *lib.LineageFormat) error {
+	counts := readCounts(files, column)
+	log.Printf("Writing %d samples to the BIOM table %s.", len(counts.Samples), out)
+	return lib.WriteBiom(counts, lib.CountLineages(counts, taxonomy, format), out)
+}
+
+// pivotBracken merges Bracken files into a matrix. Lineages are only read
+// from the taxonomy if they are requested and missing from the inputs.
+func pivotBracken(cmd *cobra.Command, files []string, out string, column string) {
+	matrix, err := lib.ReadBrackenMatrix(files, column)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lineage, _ := cmd.Flags().GetBool("lineage")
+	var lineages map[string]*lib.Lineage
+	var taxonomy lib.TaxonomyProvider
+	missing := matrix.Unannotated()
+	if lineage && len(missing) > 0 {
+		taxonomy = getProvider(cmd)
+	}
+	format := getFormat(cmd, taxonomy)
+	if taxonomy != nil {
+		lineages = lib.AddLineage(missing, taxonomy, format)
+	}
+	if err := matrix.Write(out, lineage, lineages, format); err != nil {
+		log.Fatal(err)
+	}
 }
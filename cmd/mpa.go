@@ -0,0 +1,59 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"log"
+
+	"github.com/cdiener/architeuthis/lib"
+	"github.com/spf13/cobra"
+)
+
+// mpaCmd represents the mpa command
+var mpaCmd = &cobra.Command{
+	Use:   "mpa [flags] FILE...",
+	Short: "Write MetaPhlAn-style (mpa) tables.",
+	Long: `Writes the abundances as a MetaPhlAn-style table with one row for each
+clade such as 'k__Bacteria|p__Bacillota' and one column for each sample. This is
+the same as 'kreport2mpa.py' followed by 'combine_mpa.py'.
+
+The values are the cumulative reads of each clade on the ranks of the lineage
+format, or their percentages of all classified reads with '--percentages'.
+Existing mpa tables can be combined with 'architeuthis merge'.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		taxonomy := getProvider(cmd)
+		format := getFormat(cmd, taxonomy)
+		out, _ := cmd.Flags().GetString("out")
+		percentages, _ := cmd.Flags().GetBool("percentages")
+
+		counts := readCounts(args, "new_est_reads")
+		lineages := lib.CountLineages(counts, taxonomy, format)
+		table := lib.NewMPATable(counts, lineages, format, percentages)
+		if err := table.Write(out); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Wrote %d samples to %s.", len(counts.Samples), out)
+	},
+}
+
+func init() {
+	exportCmd.AddCommand(mpaCmd)
+
+	mpaCmd.Flags().StringP("out", "o", "profiles.mpa.txt", "The output file.")
+	mpaCmd.Flags().Bool("percentages", false, "Write percentages instead of read counts.")
+}
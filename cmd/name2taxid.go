@@ -0,0 +1,60 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/cdiener/architeuthis/lib"
+	"github.com/spf13/cobra"
+)
+
+// name2taxidCmd represents the taxonomy name2taxid command
+var name2taxidCmd = &cobra.Command{
+	Use:   "name2taxid [files...]",
+	Short: "Find the taxon IDs for scientific names.",
+	Long: `Looks up the taxon IDs for scientific names, ignoring case. Every output
+line contains the name, the taxon ID and the rank. Names that match several
+taxa are listed once for each match and unknown names have an empty taxon ID.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		taxonomy := getProvider(cmd)
+		names, ok := taxonomy.(lib.NameLookup)
+		if !ok {
+			log.Fatal("the selected taxonomy backend does not support name lookups.")
+		}
+
+		writer := bufio.NewWriter(os.Stdout)
+		defer writer.Flush()
+		for _, q := range readQueries(args) {
+			taxids := names.TaxidsByName(q)
+			if len(taxids) == 0 {
+				fmt.Fprintf(writer, "%s\t\t\n", q)
+			}
+			for _, taxid := range taxids {
+				rank, _ := taxonomy.Rank(taxid)
+				fmt.Fprintf(writer, "%s\t%d\t%s\n", q, taxid, rank)
+			}
+		}
+	},
+}
+
+func init() {
+	taxonomyCmd.AddCommand(name2taxidCmd)
+}
@@ -0,0 +1,52 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// pivotCmd represents the pivot command
+var pivotCmd = &cobra.Command{
+	Use:   "pivot [flags] FILE...",
+	Short: "Pivot merged Bracken tables into a taxa-by-samples matrix.",
+	Long: `Converts Bracken tables merged by 'architeuthis merge' into a matrix with
+one row for each taxon and one column for each sample. Taxa missing from a
+sample are filled with zeros. This is the same as 'merge --wide'.
+
+'--column' selects the Bracken column used for the values, for instance
+fraction_total_reads. '--lineage' adds the lineage and taxid_lineage columns.
+Those are taken from tables annotated with 'architeuthis lineage' or read from
+the taxonomy otherwise.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		out, _ := cmd.Flags().GetString("out")
+		column, _ := cmd.Flags().GetString("column")
+		pivotBracken(cmd, args, out, column)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pivotCmd)
+
+	pivotCmd.Flags().StringP("out", "o", "matrix.csv", "The output filename.")
+	pivotCmd.Flags().StringP("column", "c", "new_est_reads", "The Bracken column with the values.")
+	pivotCmd.Flags().Bool("lineage", false, "Add lineage columns.")
+	pivotCmd.Flags().String("data-dir", "", "The path to the taxonomy dumps (only used for lineages).")
+	pivotCmd.Flags().StringP("format", "f", "{K};{p};{c};{o};{f};{g};{s}", "The taxonomic ranks used for lineages.")
+	pivotCmd.Flags().Bool("fill-miss-rank", false, "Fill missing ranks with the name of the next higher rank.")
+}
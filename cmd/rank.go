@@ -0,0 +1,49 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// rankCmd represents the taxonomy rank command
+var rankCmd = &cobra.Command{
+	Use:   "rank [files...]",
+	Short: "Show the ranks of taxon IDs.",
+	Long: `Prints the rank and scientific name of each taxon ID. Both are empty for
+unknown taxon IDs.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		taxonomy := getProvider(cmd)
+
+		writer := bufio.NewWriter(os.Stdout)
+		defer writer.Flush()
+		for _, q := range readQueries(args) {
+			taxid := parseTaxid(q)
+			rank, _ := taxonomy.Rank(taxid)
+			name, _ := taxonomy.Name(taxid)
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", q, rank, name)
+		}
+	},
+}
+
+func init() {
+	taxonomyCmd.AddCommand(rankCmd)
+}
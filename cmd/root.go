@@ -18,8 +18,14 @@ package cmd
 
 import (
 	"fmt"
+	"log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 
+	"github.com/cdiener/architeuthis/lib"
 	"github.com/spf13/cobra"
 )
 
@@ -56,4 +62,176 @@ func init() {
 	// will be global for your application.
 	var k2lib string
 	rootCmd.PersistentFlags().StringVar(&k2lib, "db", "", "path to the Kraken database [optional]")
+	rootCmd.PersistentFlags().String("taxonomy", "",
+		"the taxonomy used for lineages (ncbi or gtdb) [default: the one that was read]")
+	rootCmd.PersistentFlags().String("backend", lib.BackendAuto,
+		"how to read the taxonomy ("+strings.Join(lib.Backends, ", ")+")")
+}
+
+// getBackend returns the selected taxonomy backend. Without an explicit
+// backend `--taxonomy gtdb` selects the GTDB backend.
+func getBackend(cmd *cobra.Command) string {
+	backend, err := cmd.Flags().GetString("backend")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !slices.Contains(lib.Backends, backend) {
+		log.Fatalf("unknown taxonomy backend `%s`, must be one of %v", backend, lib.Backends)
+	}
+	if backend == lib.BackendAuto && getTaxonomy(cmd) == "gtdb" {
+		backend = lib.BackendGTDB
+	}
+	return backend
+}
+
+// getOutFormat returns the selected output format if it is one of the
+// supported formats.
+func getOutFormat(cmd *cobra.Command, supported ...string) string {
+	format, err := cmd.Flags().GetString("out-format")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !slices.Contains(supported, format) {
+		log.Fatalf("unknown output format `%s`, must be one of %v", format, supported)
+	}
+	return format
+}
+
+// addSampleFlags adds the options that control how sample IDs are derived
+// from file names.
+func addSampleFlags(cmd *cobra.Command) {
+	cmd.Flags().String("sample-id-regex", "",
+		"Derive sample IDs from file paths with a regular expression, using the first group if it has one.")
+	cmd.Flags().String("samples", "", "A CSV file with path and sample_id columns and optional metadata columns.")
+}
+
+// setSampleNames configures how sample IDs are derived from file names.
+func setSampleNames(cmd *cobra.Command) {
+	sheet, _ := cmd.Flags().GetString("samples")
+	pattern, _ := cmd.Flags().GetString("sample-id-regex")
+	names := &lib.SampleNames{}
+	if sheet != "" {
+		var err error
+		if names, err = lib.ReadSampleSheet(sheet); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if pattern != "" {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Fatalf("invalid sample ID regex: %v", err)
+		}
+		names.Regex = regex
+	}
+	lib.SetSampleNames(names)
+}
+
+// getProvider creates the taxonomy provider for the selected backend.
+func getProvider(cmd *cobra.Command) lib.TaxonomyProvider {
+	backend := getBackend(cmd)
+	provider, err := lib.NewTaxonomyProvider(backend, getDataDir(cmd))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ft, ok := provider.(*lib.FileTaxonomy); ok {
+		backend = ft.Backend
+		log.Printf("Reading the taxonomy from %s (%s).", ft.Path, ft.Backend)
+	} else {
+		log.Printf("Reading the taxonomy with the %s backend.", backend)
+	}
+	taxonomy, loaded := getTaxonomy(cmd), backendTaxonomy(provider)
+	if taxonomy != "" && loaded != "" && taxonomy != loaded {
+		log.Fatalf("the %s taxonomy can not be used with the %s backend", taxonomy, backend)
+	}
+	return provider
+}
+
+// getTaxonomy returns the taxonomy selected with `--taxonomy`, or an empty
+// string if none was selected.
+func getTaxonomy(cmd *cobra.Command) string {
+	taxonomy, err := cmd.Flags().GetString("taxonomy")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if taxonomy != "" && taxonomy != "ncbi" && taxonomy != "gtdb" {
+		log.Fatalf("unknown taxonomy `%s`, must be ncbi or gtdb", taxonomy)
+	}
+	return taxonomy
+}
+
+// backendTaxonomy returns the taxonomy a provider reads, or an empty string
+// if it may be either (Kraken2 databases can be built on NCBI or GTDB).
+func backendTaxonomy(provider lib.TaxonomyProvider) string {
+	ft, ok := provider.(*lib.FileTaxonomy)
+	switch {
+	case ok && ft.Backend == lib.BackendGTDB:
+		return "gtdb"
+	case ok && ft.Backend == lib.BackendKraken:
+		return ""
+	}
+	return "ncbi"
+}
+
+// loadedTaxonomy returns the selected taxonomy or the one read by the
+// provider. A nil provider means that no taxonomy is read.
+func loadedTaxonomy(cmd *cobra.Command, provider lib.TaxonomyProvider) string {
+	if taxonomy := getTaxonomy(cmd); taxonomy != "" {
+		return taxonomy
+	}
+	if provider != nil && backendTaxonomy(provider) == "gtdb" {
+		return "gtdb"
+	}
+	return "ncbi"
+}
+
+// getFormat returns the lineage format. GTDB taxonomies default to GTDB ranks.
+func getFormat(cmd *cobra.Command, provider lib.TaxonomyProvider) *lib.LineageFormat {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !cmd.Flags().Changed("format") && loadedTaxonomy(cmd, provider) == "gtdb" {
+		format = lib.GTDBFormat
+	}
+	parsed, err := lib.ParseFormat(format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(parsed.Ranks) == 0 {
+		log.Fatalf("the format `%s` does not contain any ranks", format)
+	}
+	if cmd.Flags().Lookup("fill-miss-rank") != nil {
+		parsed.FillMissing, _ = cmd.Flags().GetBool("fill-miss-rank")
+	}
+	return parsed
+}
+
+// getDataDir resolves the taxonomy location from `--data-dir` or `--db`.
+func getDataDir(cmd *cobra.Command) string {
+	datadir, err := cmd.Flags().GetString("data-dir")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if datadir == "" {
+		k2lib, err := cmd.Flags().GetString("db")
+		if err == nil && k2lib != "" {
+			backend := getBackend(cmd)
+			k2d := lib.KrakenTaxonomyFile(k2lib)
+			switch {
+			case backend == lib.BackendGTDB:
+				datadir = k2lib
+			case k2d != "" && (backend == lib.BackendAuto || backend == lib.BackendKraken):
+				datadir = k2d
+			default:
+				datadir = filepath.Join(k2lib, "taxonomy")
+			}
+			log.Printf("Using the taxonomy from the Kraken2 database at `%s`.", k2lib)
+		}
+	} else {
+		log.Printf("Using the taxonomy dump at `%s`.", datadir)
+	}
+	if getBackend(cmd) == lib.BackendGTDB && datadir == "" {
+		log.Fatal("the GTDB taxonomy requires `--data-dir` or `--db`.")
+	}
+	return datadir
 }
@@ -44,25 +44,14 @@ assignment would be very ambiguous but the genus assignment would not be. We rep
 the number of different classifications (multiplicity) and the shannon index (taking
 abundance of kmers into account as well).
 `,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		datadir, err := cmd.Flags().GetString("data-dir")
-		if err != nil {
-			log.Fatal(err)
-		}
-		format, err := cmd.Flags().GetString("format")
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		version, ok := lib.HasTaxonkit()
-		if !ok {
-			log.Fatal("no taxonkit installation could be found :(")
-		} else {
-			log.Printf("Found taxonkit=%s.", version)
-		}
+		setSampleNames(cmd)
+		taxonomy := getProvider(cmd)
+		format := getFormat(cmd, taxonomy)
 		filetype, named := lib.GetFormat(args[0])
-		if filetype != "kraken2" {
-			log.Fatal("read scoring requires a Kraken2 file.")
+		if !lib.IsClassification(filetype) {
+			log.Fatal("read scoring requires a Kraken2, Centrifuge or Kaiju file.")
 		}
 		if named {
 			log.Println("detected Kraken2 output with taxon names.")
@@ -70,7 +59,7 @@ abundance of kmers into account as well).
 
 		out, _ := cmd.Flags().GetString("out")
 
-		err = lib.ScoreReadsToFile(args[0], out, datadir, format, named)
+		err := lib.ScoreReadsToFile(args[0], out, taxonomy, format, named)
 		if err != nil {
 			log.Fatalf("Saving file failed with error: %v", err)
 		}
@@ -82,5 +71,7 @@ func init() {
 
 	scoreCmd.Flags().String("out", "mapping_scores.csv", "The output file (CSV format).")
 	scoreCmd.Flags().String("data-dir", "", "The path to the taxonomy dumps.")
-	scoreCmd.Flags().StringP("format", "f", "{K};{p};{c};{o};{f};{g};{s}", "The taxonomic ranks to connsider during scoring.")
+	scoreCmd.Flags().StringP("format", "f", "{K};{p};{c};{o};{f};{g};{s}", "The taxonomic ranks to consider during scoring.")
+	scoreCmd.Flags().Bool("fill-miss-rank", false, "Fill missing ranks with the name of the next higher rank.")
+	addSampleFlags(scoreCmd)
 }
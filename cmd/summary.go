@@ -18,8 +18,6 @@ package cmd
 
 import (
 	"log"
-	"path/filepath"
-	"strings"
 
 	"github.com/cdiener/architeuthis/lib"
 	"github.com/spf13/cobra"
@@ -33,36 +31,25 @@ var summaryCmd = &cobra.Command{
 on taxonomic ranks. This allows you to see whether assignments are consistent on
 higher ranks. For instance, even though a taxon might have discordant species
 assignments those might all be within the same family or genus.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		datadir, err := cmd.Flags().GetString("data-dir")
-		if err != nil {
-			log.Fatal(err)
-		}
-		format, err := cmd.Flags().GetString("format")
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		version, ok := lib.HasTaxonkit()
-		if !ok {
-			log.Fatal("no taxonkit installation could be found :(")
-		} else {
-			log.Printf("Found taxonkit=%s.", version)
-		}
+		setSampleNames(cmd)
+		taxonomy := getProvider(cmd)
+		format := getFormat(cmd, taxonomy)
 		filetype, named := lib.GetFormat(args[0])
-		if filetype != "kraken2" {
-			log.Fatal("mapping summaries require a Kraken2 file")
+		if !lib.IsClassification(filetype) {
+			log.Fatal("mapping summaries require a Kraken2, Centrifuge or Kaiju file")
 		}
 		if named {
 			log.Println("detected Kraken2 output with taxon names.")
 		}
 
-		id := strings.Split(filepath.Base(args[0]), ".")[0]
-		kmap, err := lib.SummarizeKmers(args[0], named)
+		id := lib.SampleID(args[0])
+		kmap, err := lib.SummarizeKmers(args[0], named, taxonomy)
 		if err != nil {
 			log.Fatal("Failed to build the kmer mapping hash.")
 		}
-		collapsed := lib.CollapseRanks(kmap, datadir, format)
+		collapsed := lib.CollapseRanks(kmap, taxonomy, format)
 
 		out, _ := cmd.Flags().GetString("out")
 		log.Printf("Saving map to %s.", out)
@@ -84,6 +71,8 @@ func init() {
 	// summaryCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	summaryCmd.Flags().String("out", "mapping_summary.csv", "The output file (CSV format).")
 	summaryCmd.Flags().String("data-dir", "", "The path to the taxonomy dumps.")
-	summaryCmd.Flags().StringP("format", "f", "{K};{p};{c};{o};{f};{g};{s}", "The taxonomic ranks to connsider during scoring.")
+	summaryCmd.Flags().StringP("format", "f", "{K};{p};{c};{o};{f};{g};{s}", "The taxonomic ranks to consider during scoring.")
+	summaryCmd.Flags().Bool("fill-miss-rank", false, "Fill missing ranks with the name of the next higher rank.")
 
+	addSampleFlags(summaryCmd)
 }
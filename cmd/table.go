@@ -0,0 +1,126 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/csv"
+	"log"
+	"strconv"
+
+	"github.com/cdiener/architeuthis/lib"
+	"github.com/spf13/cobra"
+)
+
+// tableCmd represents the table command
+var tableCmd = &cobra.Command{
+	Use:   "table [files...]",
+	Short: "Build a table of cumulative clade counts.",
+	Long: `Pushes the counts of each taxon up through all of its ancestors, so every
+taxon gets the total count of its clade, similar to the second column of a
+Kraken2 report. This works on Bracken output, Bracken files merged by
+architeuthis, Kraken2 output, where the classified reads are counted, and
+Kraken2 reports, where the reads classified directly to each taxon are used.
+
+For Bracken files, '--column' selects the counts to use.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		taxonomy := getProvider(cmd)
+		column, _ := cmd.Flags().GetString("column")
+		out, _ := cmd.Flags().GetString("out")
+
+		var all lib.Counts
+		for _, filename := range args {
+			filetype, _ := lib.GetFormat(filename)
+			if filetype != "bracken" && filetype != "bracken-merged" && filetype != "kraken2" &&
+				!lib.IsReport(filetype) {
+				log.Fatalf("file %s is not in Bracken or Kraken2 format", filename)
+			}
+			counts, err := lib.ReadCounts(filename, filetype, column)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := all.Merge(counts); err != nil {
+				log.Fatal(err)
+			}
+		}
+		samples, values := all.Samples, all.Values
+		query := all.Taxids()
+		log.Printf("Read counts for %d taxa in %d samples.", len(query), len(samples))
+
+		tree, err := lib.Subtree(taxonomy, query)
+		if err != nil {
+			log.Fatalf("could not read the taxonomy: %v", err)
+		}
+		if tree.Root == nil {
+			log.Fatal("no taxa found in the taxonomy")
+		}
+
+		outfile, err := lib.CreateFile(out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		writer := csv.NewWriter(outfile)
+		writer.Write([]string{"sample_id", "taxid", "name", "rank", "depth",
+			"reads", "clade_reads", "clade_fraction"})
+		for _, s := range samples {
+			tree.ResetValues()
+			missing := 0
+			for taxid, v := range values[s] {
+				if !tree.AddValue(taxid, v) {
+					missing++
+				}
+			}
+			if missing > 0 {
+				log.Printf("%d taxa in sample %s are not in the taxonomy and were skipped.", missing, s)
+			}
+			direct := make(map[int]float64, len(tree.Taxids))
+			for taxid, node := range tree.Taxids {
+				direct[taxid] = node.Value
+			}
+			tree.Accumulate()
+			total := tree.Root.Value
+			if total == 0 {
+				log.Fatalf("no taxa of sample %s found in the taxonomy", s)
+			}
+			tree.Walk(func(node *lib.Node, depth int) {
+				if node.Value == 0 {
+					return
+				}
+				writer.Write([]string{
+					s, strconv.Itoa(node.Taxid), node.Name, node.Rank, strconv.Itoa(depth),
+					lib.FormatValue(direct[node.Taxid]), lib.FormatValue(node.Value),
+					lib.FormatValue(node.Value / total)})
+			})
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			log.Fatal(err)
+		}
+		if err := outfile.Close(); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Wrote clade counts to %s.", out)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tableCmd)
+
+	tableCmd.Flags().String("data-dir", "", "The path to the taxonomy dumps.")
+	tableCmd.Flags().StringP("column", "c", "new_est_reads", "The Bracken column with the counts.")
+	tableCmd.Flags().StringP("out", "o", "clades.csv", "The output file (CSV format).")
+}
@@ -0,0 +1,61 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/cdiener/architeuthis/lib"
+	"github.com/spf13/cobra"
+)
+
+// taxLineageCmd represents the taxonomy lineage command
+var taxLineageCmd = &cobra.Command{
+	Use:   "lineage [files...]",
+	Short: "Show the lineages of taxon IDs.",
+	Long: `Prints the lineage of each taxon ID. The output contains the taxon ID, the
+lineage, the taxon ID lineage and the current taxon ID, which differs from the
+queried one for merged taxa and is empty for deleted or unknown ones.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		taxonomy := getProvider(cmd)
+		format := getFormat(cmd, taxonomy)
+		queries := readQueries(args)
+
+		taxids := make(map[string]bool, len(queries))
+		for _, q := range queries {
+			taxids[q] = true
+		}
+		lineages := lib.AddLineage(taxids, taxonomy, format)
+
+		writer := bufio.NewWriter(os.Stdout)
+		defer writer.Flush()
+		for _, q := range queries {
+			l := lineages[q]
+			writer.WriteString(strings.Join(
+				[]string{q, format.Join(l.Names), format.Join(l.Taxids), l.Taxid}, "\t") + "\n")
+		}
+	},
+}
+
+func init() {
+	taxonomyCmd.AddCommand(taxLineageCmd)
+
+	taxLineageCmd.Flags().StringP("format", "f", "{K};{p};{c};{o};{f};{g};{s}", "The taxonomic ranks to include in the lineage.")
+	taxLineageCmd.Flags().Bool("fill-miss-rank", false, "Fill missing ranks with the name of the next higher rank.")
+}
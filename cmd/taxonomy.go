@@ -0,0 +1,80 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/cdiener/architeuthis/lib"
+	"github.com/spf13/cobra"
+)
+
+// taxonomyCmd represents the taxonomy command
+var taxonomyCmd = &cobra.Command{
+	Use:   "taxonomy",
+	Short: "Work with the taxonomy used for lineage annotation.",
+	Long: `The taxonomy command contains tools to prepare and query the taxonomy
+that architeuthis uses for lineage annotation.
+
+Query commands read one taxon ID or name per line from the files given as
+arguments or from stdin and write tab-separated results to stdout.`,
+}
+
+func init() {
+	rootCmd.AddCommand(taxonomyCmd)
+
+	taxonomyCmd.PersistentFlags().String("data-dir", "", "The path to the taxonomy dumps.")
+}
+
+// readQueries reads the non-empty lines from the files or stdin if there are
+// no files or the file is "-".
+func readQueries(args []string) []string {
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+	var queries []string
+	for _, path := range args {
+		file, err := lib.OpenFile(path)
+		if err != nil {
+			log.Fatalf("could not open %s: %v", path, err)
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				queries = append(queries, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatalf("could not read %s: %v", path, err)
+		}
+		file.Close()
+	}
+	return queries
+}
+
+// parseTaxid converts a query to a taxon ID.
+func parseTaxid(query string) int {
+	taxid, err := strconv.Atoi(query)
+	if err != nil {
+		log.Fatalf("`%s` is not a valid taxon ID", query)
+	}
+	return taxid
+}
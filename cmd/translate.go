@@ -0,0 +1,112 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"log"
+
+	"github.com/cdiener/architeuthis/lib"
+	"github.com/spf13/cobra"
+)
+
+var translatedNames = map[string]string{
+	"kraken2":        "translated.k2",
+	"bracken":        "translated.b2",
+	"bracken-merged": "translated.csv",
+}
+
+// translateCmd represents the translate command
+var translateCmd = &cobra.Command{
+	Use:   "translate [file]",
+	Short: "Translate Kraken2 or Bracken output to another taxonomy.",
+	Long: `Rewrites the classifications of a Kraken2 output, a Bracken output or a
+merged Bracken table into a target taxonomy, for instance from NCBI to GTDB.
+
+The mapping between the taxonomies is read from a tab-separated crosswalk with
+taxon IDs or names of the source and target taxa, such as the GTDB metadata
+tables. Source taxa that map to several target taxa are assigned to the lowest
+common ancestor in the target taxonomy. Taxa missing from the crosswalk are
+assigned to the lowest common ancestor of all their descendants that are in the
+crosswalk. Taxa that can not be mapped are reported.
+
+The source taxonomy is set with '--db', '--data-dir' and '--backend' as usual
+and the target taxonomy with '--target' and '--target-backend'.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		source := getProvider(cmd)
+		target_dir, _ := cmd.Flags().GetString("target")
+		target_backend, _ := cmd.Flags().GetString("target-backend")
+		target, err := lib.NewTaxonomyProvider(target_backend, target_dir)
+		if err != nil {
+			log.Fatalf("could not open the target taxonomy: %v", err)
+		}
+
+		path, _ := cmd.Flags().GetString("crosswalk")
+		from, _ := cmd.Flags().GetString("from-column")
+		to, _ := cmd.Flags().GetString("to-column")
+		crosswalk, err := lib.ReadCrosswalk(path, from, to, source, target)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Read crosswalk entries for %d source taxa from %s.", len(crosswalk), path)
+		translator, err := lib.NewTranslator(source, target, crosswalk)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		filetype, named := lib.GetFormat(args[0])
+		out, _ := cmd.Flags().GetString("out")
+		if out == "" {
+			out = translatedNames[filetype]
+		}
+		switch filetype {
+		case "kraken2":
+			err = lib.TranslateKraken(args[0], out, translator, named)
+		case "bracken", "bracken-merged":
+			err = lib.TranslateBracken(args[0], out, filetype, translator)
+		default:
+			log.Fatalf("file %s is not a Kraken2 or Bracken file", args[0])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Wrote the translated data to %s.", out)
+
+		unmapped, _ := cmd.Flags().GetString("unmapped")
+		if unmapped != "" {
+			if err := translator.SaveUnmapped(unmapped); err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Wrote the unmapped taxa to %s.", unmapped)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(translateCmd)
+
+	translateCmd.Flags().String("data-dir", "", "The path to the taxonomy dumps.")
+	translateCmd.Flags().String("crosswalk", "", "The table mapping source to target taxa.")
+	translateCmd.Flags().String("from-column", "", "The crosswalk column with the source taxa (default first column).")
+	translateCmd.Flags().String("to-column", "", "The crosswalk column with the target taxa (default second column).")
+	translateCmd.Flags().String("target", "", "The location of the target taxonomy.")
+	translateCmd.Flags().String("target-backend", lib.BackendAuto, "How to read the target taxonomy.")
+	translateCmd.Flags().StringP("out", "o", "", "The output file (default translated.k2, .b2 or .csv).")
+	translateCmd.Flags().String("unmapped", "", "Write the unmapped source taxa to this file (CSV format).")
+	translateCmd.MarkFlagRequired("crosswalk")
+	translateCmd.MarkFlagRequired("target")
+}
@@ -1,8 +1,12 @@
 module github.com/cdiener/architeuthis
 
-go 1.21.1
+go 1.22
 
-require github.com/spf13/cobra v1.7.0
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/klauspost/pgzip v1.2.6
+	github.com/spf13/cobra v1.7.0
+)
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
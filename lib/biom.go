@@ -0,0 +1,110 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const biomFormat = "Biological Observation Matrix 1.0.0"
+
+// BiomEntry is a row or column of a BIOM table.
+type BiomEntry struct {
+	ID       string         `json:"id"`
+	Metadata map[string]any `json:"metadata"`
+}
+
+// BiomTable is a sparse table in the BIOM 1.0 JSON format.
+type BiomTable struct {
+	ID                string       `json:"id"`
+	Format            string       `json:"format"`
+	FormatURL         string       `json:"format_url"`
+	Type              string       `json:"type"`
+	GeneratedBy       string       `json:"generated_by"`
+	Date              string       `json:"date"`
+	Rows              []BiomEntry  `json:"rows"`
+	Columns           []BiomEntry  `json:"columns"`
+	MatrixType        string       `json:"matrix_type"`
+	MatrixElementType string       `json:"matrix_element_type"`
+	Shape             [2]int       `json:"shape"`
+	Data              [][3]float64 `json:"data"`
+}
+
+// NewBiomTable builds a BIOM table with one row for each taxon and one column
+// for each sample. The names of the lineages are added to the rows as the
+// `taxonomy` metadata. Lineages may be nil.
+func NewBiomTable(id string, counts *Counts, lineages map[string]*Lineage) *BiomTable {
+	taxids := counts.Taxids()
+	table := &BiomTable{
+		ID:                id,
+		Format:            biomFormat,
+		FormatURL:         "http://biom-format.org",
+		Type:              "Taxon table",
+		GeneratedBy:       "architeuthis",
+		Date:              time.Now().Format("2006-01-02T15:04:05"),
+		Rows:              make([]BiomEntry, len(taxids)),
+		Columns:           make([]BiomEntry, len(counts.Samples)),
+		MatrixType:        "sparse",
+		MatrixElementType: "int",
+		Shape:             [2]int{len(taxids), len(counts.Samples)},
+		Data:              [][3]float64{},
+	}
+
+	row := make(map[int]int, len(taxids))
+	for i, taxid := range taxids {
+		row[taxid] = i
+		tid := strconv.Itoa(taxid)
+		table.Rows[i] = BiomEntry{ID: tid}
+		if lin, ok := lineages[tid]; ok {
+			table.Rows[i].Metadata = map[string]any{"taxonomy": lin.Names}
+		}
+	}
+	for j, s := range counts.Samples {
+		table.Columns[j] = BiomEntry{ID: s}
+		for _, taxid := range taxids {
+			value, ok := counts.Values[s][taxid]
+			if !ok || value == 0 {
+				continue
+			}
+			if value != math.Trunc(value) {
+				table.MatrixElementType = "float"
+			}
+			table.Data = append(table.Data, [3]float64{float64(row[taxid]), float64(j), value})
+		}
+	}
+	return table
+}
+
+// WriteBiom writes counts as a BIOM 1.0 table with the lineages of all taxa.
+func WriteBiom(counts *Counts, lineages map[string]*Lineage, out string) (err error) {
+	file, err := CreateFile(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	table := NewBiomTable(strings.Split(filepath.Base(out), ".")[0], counts, lineages)
+	return json.NewEncoder(file).Encode(table)
+}
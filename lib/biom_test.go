@@ -0,0 +1,52 @@
+package lib
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestBiom(t *testing.T) {
+	counts := &Counts{
+		Samples: []string{"A", "B"},
+		Values: map[string]map[int]float64{
+			"A": {562: 10, 817: 5},
+			"B": {817: 2.5},
+		},
+	}
+	lineages := AddLineage(map[string]bool{"562": true, "817": true}, DetectTaxonomy(taxdump),
+		MustParseFormat("{K};{p};{c};{o};{f};{g};{s}"))
+	table := NewBiomTable("test", counts, lineages)
+	if table.Shape != [2]int{2, 2} || table.MatrixElementType != "float" || len(table.Data) != 3 {
+		t.Errorf("Expected a 2x2 float table with 3 entries but got %v.", table)
+	}
+	if table.Rows[0].ID != "562" || table.Columns[1].ID != "B" {
+		t.Errorf("Unexpected rows %v or columns %v.", table.Rows, table.Columns)
+	}
+	if table.Data[2] != [3]float64{1, 1, 2.5} {
+		t.Errorf("Expected entry [1 1 2.5] but got %v.", table.Data[2])
+	}
+	taxonomy := table.Rows[1].Metadata["taxonomy"].([]string)
+	if len(taxonomy) != 7 || taxonomy[6] != "s__Bacteroides fragilis" {
+		t.Errorf("Unexpected taxonomy %v.", taxonomy)
+	}
+
+	out := filepath.Join(t.TempDir(), "table.biom")
+	err := WriteBiom(counts, lineages, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(out)
+	var parsed map[string]any
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("Could not parse the BIOM table: %v", err)
+	}
+	if parsed["format"] != biomFormat || parsed["matrix_type"] != "sparse" || parsed["id"] != "table" {
+		t.Errorf("Unexpected BIOM header %v.", parsed)
+	}
+	if !slices.Equal(counts.Taxids(), []int{562, 817}) {
+		t.Errorf("Expected taxids [562 817] but got %v.", counts.Taxids())
+	}
+}
@@ -0,0 +1,358 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Counts maps taxon IDs to counts, for instance read counts, for each sample.
+type Counts struct {
+	Samples []string
+	Values  map[string]map[int]float64
+}
+
+// Merge adds the samples of other to the counts. Samples may only appear once.
+func (c *Counts) Merge(other *Counts) error {
+	if c.Values == nil {
+		c.Values = make(map[string]map[int]float64)
+	}
+	for _, s := range other.Samples {
+		if _, ok := c.Values[s]; ok {
+			return fmt.Errorf("sample %s appears more than once", s)
+		}
+		c.Samples = append(c.Samples, s)
+		c.Values[s] = other.Values[s]
+	}
+	return nil
+}
+
+// Taxids returns the sorted taxon IDs of all samples.
+func (c *Counts) Taxids() []int {
+	seen := make(map[int]bool)
+	for _, values := range c.Values {
+		for taxid := range values {
+			seen[taxid] = true
+		}
+	}
+	taxids := make([]int, 0, len(seen))
+	for taxid := range seen {
+		taxids = append(taxids, taxid)
+	}
+	slices.Sort(taxids)
+	return taxids
+}
+
+// CountLineages obtains the lineages of all taxa in the counts.
+func CountLineages(counts *Counts, taxonomy TaxonomyProvider, format *LineageFormat) map[string]*Lineage {
+	taxids := make(map[string]bool)
+	for _, taxid := range counts.Taxids() {
+		taxids[strconv.Itoa(taxid)] = true
+	}
+	lineages := AddLineage(taxids, taxonomy, format)
+	var remapped RemapSummary
+	for _, lin := range lineages {
+		remapped.Count(lin, 1)
+	}
+	remapped.Log("taxa")
+	return lineages
+}
+
+// ReadCounts reads the counts for each taxon from a column of a Bracken file
+// ("bracken") or Bracken files merged by architeuthis ("bracken-merged"). For
+// Kraken2 output ("kraken2") this counts the reads classified to each taxon
+// and for Kraken2 or KrakenUniq reports ("report" or "krakenuniq-report") it
+// uses the reads classified directly to each taxon. For mapping summaries
+// ("mapping") it uses the reads of each classification.
+func ReadCounts(filename string, filetype string, column string) (*Counts, error) {
+	counts := &Counts{Values: make(map[string]map[int]float64)}
+	sample_id := SampleID(filename)
+	if IsReport(filetype) {
+		report, err := ReadReport(filename)
+		if err != nil {
+			return nil, err
+		}
+		values := make(map[int]float64, len(report.Direct))
+		for taxid, n := range report.Direct {
+			if n > 0 {
+				values[taxid] = n
+			}
+		}
+		counts.Samples = []string{sample_id}
+		counts.Values[sample_id] = values
+		return counts, nil
+	}
+
+	file, err := OpenFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if filetype == "kraken2" {
+		values := make(map[int]float64)
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			tokens := strings.Split(strings.Trim(scanner.Text(), " "), "\t")
+			if tokens[0] != "C" || len(tokens) < 3 {
+				continue
+			}
+			taxid, err := strconv.Atoi(TaxID(tokens[2], !isNumeric(tokens[2])))
+			if err != nil {
+				return nil, fmt.Errorf("invalid taxon ID %s in %s", tokens[2], filename)
+			}
+			values[taxid]++
+		}
+		counts.Samples = []string{sample_id}
+		counts.Values[sample_id] = values
+		return counts, scanner.Err()
+	}
+
+	reader := csv.NewReader(file)
+	if filetype == "bracken" {
+		reader.Comma = '\t'
+	}
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	tid_column := "taxonomy_id"
+	if filetype == "mapping" {
+		// Mapping summaries repeat the reads of a classification on every row.
+		tid_column, column = "classification", "total_reads"
+	}
+	tid_idx := slices.Index(header, tid_column)
+	val_idx := slices.Index(header, column)
+	sample_idx := slices.Index(header, "sample_id")
+	if tid_idx < 0 || val_idx < 0 {
+		return nil, fmt.Errorf("%s has no `%s` or `%s` column", filename, tid_column, column)
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if (filetype == "bracken-merged" || filetype == "mapping") && sample_idx >= 0 {
+			sample_id = record[sample_idx]
+		}
+		taxid, err := strconv.Atoi(record[tid_idx])
+		if err != nil {
+			return nil, fmt.Errorf("invalid taxon ID %s in %s", record[tid_idx], filename)
+		}
+		value, err := strconv.ParseFloat(record[val_idx], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %s in %s", record[val_idx], filename)
+		}
+		values, ok := counts.Values[sample_id]
+		if !ok {
+			values = make(map[int]float64)
+			counts.Values[sample_id] = values
+			counts.Samples = append(counts.Samples, sample_id)
+		}
+		if filetype == "mapping" {
+			values[taxid] = value
+		} else {
+			values[taxid] += value
+		}
+	}
+
+	return counts, nil
+}
+
+// Subtree builds the part of a taxonomy that contains the given taxa and all
+// their ancestors. Merged taxon IDs are replaced by their current ID and
+// deleted or unknown taxa are skipped.
+func Subtree(taxonomy TaxonomyProvider, taxids []int) (*Tree, error) {
+	if lookup, ok := taxonomy.(AncestorLookup); ok {
+		return ancestorSubtree(lookup, taxids)
+	}
+	queries := make([]string, len(taxids))
+	for i, taxid := range taxids {
+		queries[i] = strconv.Itoa(taxid)
+	}
+	lineages, err := taxonomy.Lineages(queries, "")
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &Tree{Taxids: make(map[int]*Node), Merged: make(map[int]int), Deleted: make(map[int]bool)}
+	for _, q := range queries {
+		lin := lineages[q]
+		if lin.Deleted {
+			tree.Deleted[atoi(q)] = true
+		}
+		if lin.Taxid == "" {
+			continue
+		}
+		taxid := atoi(lin.Taxid)
+		if lin.Merged {
+			tree.Merged[atoi(q)] = taxid
+		}
+
+		var child *Node
+		for {
+			node, seen := tree.Taxids[taxid]
+			if !seen {
+				node = &Node{Taxid: taxid}
+				node.Name, _ = taxonomy.Name(taxid)
+				node.Rank, _ = taxonomy.Rank(taxid)
+				tree.Taxids[taxid] = node
+			}
+			if child != nil {
+				child.Parent = node
+				node.Children = append(node.Children, child)
+			}
+			if seen {
+				break
+			}
+			parent, ok := taxonomy.Parent(taxid)
+			if !ok {
+				tree.Root = node
+				break
+			}
+			child, taxid = node, parent
+		}
+	}
+
+	return tree, nil
+}
+
+// ancestorSubtree builds the subtree from the ancestor paths of the taxa.
+func ancestorSubtree(lookup AncestorLookup, taxids []int) (*Tree, error) {
+	paths, err := lookup.Ancestors(taxids)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &Tree{Taxids: make(map[int]*Node), Merged: make(map[int]int), Deleted: make(map[int]bool)}
+	for _, q := range taxids {
+		path, ok := paths[q]
+		if !ok {
+			continue
+		}
+		if path.Deleted {
+			tree.Deleted[q] = true
+		}
+		if path.Taxid == 0 {
+			continue
+		}
+		if path.Merged {
+			tree.Merged[q] = path.Taxid
+		}
+
+		var parent *Node
+		for i, taxid := range path.Taxids {
+			node, seen := tree.Taxids[taxid]
+			if !seen {
+				node = &Node{Taxid: taxid, Name: path.Names[i], Rank: path.Ranks[i], Parent: parent}
+				tree.Taxids[taxid] = node
+				if parent != nil {
+					parent.Children = append(parent.Children, node)
+				} else if tree.Root == nil {
+					tree.Root = node
+				} else {
+					return nil, fmt.Errorf("taxon %d has the root %d instead of %d",
+						q, taxid, tree.Root.Taxid)
+				}
+			}
+			parent = node
+		}
+	}
+
+	return tree, nil
+}
+
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
+
+func isNumeric(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// ResetValues sets the values of all nodes to zero.
+func (t *Tree) ResetValues() {
+	for _, node := range t.Taxids {
+		node.Value = 0
+	}
+}
+
+// AddValue adds to the value of a taxon. This is false if the taxon is not in
+// the tree.
+func (t *Tree) AddValue(taxid int, value float64) bool {
+	node, ok := t.Resolve(taxid)
+	if ok {
+		node.Value += value
+	}
+	return ok
+}
+
+// Accumulate adds the values of all descendants to each node, so every node
+// holds the total of its clade. This is what the second column of a Kraken
+// report contains.
+func (t *Tree) Accumulate() {
+	if t.Root == nil {
+		return
+	}
+	order := []*Node{t.Root}
+	for i := 0; i < len(order); i++ {
+		order = append(order, order[i].Children...)
+	}
+	for i := len(order) - 1; i > 0; i-- {
+		if order[i].Parent != nil {
+			order[i].Parent.Value += order[i].Value
+		}
+	}
+}
+
+// Walk visits the nodes depth-first starting from the root. Children are
+// visited in order of decreasing value as in a Kraken report.
+func (t *Tree) Walk(visit func(node *Node, depth int)) {
+	if t.Root == nil {
+		return
+	}
+	var walk func(node *Node, depth int)
+	walk = func(node *Node, depth int) {
+		visit(node, depth)
+		children := slices.Clone(node.Children)
+		slices.SortStableFunc(children, func(a, b *Node) int {
+			if a.Value != b.Value {
+				if a.Value > b.Value {
+					return -1
+				}
+				return 1
+			}
+			return a.Taxid - b.Taxid
+		})
+		for _, c := range children {
+			walk(c, depth+1)
+		}
+	}
+	walk(t.Root, 0)
+}
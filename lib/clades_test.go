@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCladeCounts(t *testing.T) {
+	tree, err := Subtree(DetectTaxonomy(taxdump), []int{820, 821, 1000002, 1000003, 83333})
+	if err != nil {
+		t.Fatalf("Could not build the subtree: %v", err)
+	}
+	if tree.Root == nil || tree.Root.Taxid != 1 {
+		t.Fatal("Expected the subtree to contain the root.")
+	}
+	if _, ok := tree.Taxids[1000002]; ok {
+		t.Error("Merged taxa should be replaced by their current ID.")
+	}
+	if len(tree.Taxids[562].Children) != 1 {
+		t.Errorf("Expected 1 child of 562 but got %d.", len(tree.Taxids[562].Children))
+	}
+
+	for taxid, v := range map[int]float64{820: 10, 821: 5, 1000002: 3, 83333: 1, 1000003: 7} {
+		tree.AddValue(taxid, v)
+	}
+	tree.Accumulate()
+	expected := map[int]float64{1: 19, 2: 19, 815: 15, 816: 10, 543: 4, 562: 4, 83333: 1}
+	for taxid, v := range expected {
+		if tree.Taxids[taxid].Value != v {
+			t.Errorf("Expected clade count %g for %d but got %g.", v, taxid, tree.Taxids[taxid].Value)
+		}
+	}
+
+	var order []int
+	tree.Walk(func(node *Node, depth int) {
+		if node.Rank == "species" {
+			order = append(order, node.Taxid)
+		}
+	})
+	if len(order) != 3 || order[0] != 820 || order[1] != 821 || order[2] != 562 {
+		t.Errorf("Expected species in order of decreasing counts but got %v.", order)
+	}
+
+	tree.ResetValues()
+	if tree.Root.Value != 0 {
+		t.Errorf("Expected reset values but got %g.", tree.Root.Value)
+	}
+}
+
+func TestReadCounts(t *testing.T) {
+	counts, err := ReadCounts(filepath.Join("..", "testdata", "test.b2"), "bracken", "new_est_reads")
+	if err != nil {
+		t.Fatalf("Could not read the counts: %v", err)
+	}
+	if len(counts.Samples) != 1 || counts.Samples[0] != "test" {
+		t.Fatalf("Expected a single sample but got %v.", counts.Samples)
+	}
+	if counts.Values["test"][816] != 2222764 {
+		t.Errorf("Expected %d reads but got %g.", 2222764, counts.Values["test"][816])
+	}
+
+	counts, err = ReadCounts(filepath.Join("..", "testdata", "test.k2"), "kraken2", "")
+	if err != nil {
+		t.Fatalf("Could not read the counts: %v", err)
+	}
+	if counts.Values["test"][816] != 93 {
+		t.Errorf("Expected %d reads but got %g.", 93, counts.Values["test"][816])
+	}
+
+	k2map, _ := SummarizeKmers(filepath.Join("..", "testdata", "test.k2"), false, nil)
+	summary := filepath.Join(t.TempDir(), "summary.csv")
+	SaveMapping(CollapseRanks(k2map, DetectTaxonomy(taxdump), MustParseFormat("{K};{p};{c};{o};{f};{g};{s}")),
+		summary, "S1")
+	counts, err = ReadCounts(summary, "mapping", "")
+	if err != nil {
+		t.Fatalf("Could not read the counts: %v", err)
+	}
+	if counts.Samples[0] != "S1" || counts.Values["S1"][816] != 93 {
+		t.Errorf("Expected %d reads but got %g.", 93, counts.Values["S1"][816])
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var centrifuge_header = []string{"readID", "seqID", "taxID", "score", "2ndBestScore",
+	"hitLength", "queryLength", "numMatches"}
+
+// IsClassification checks whether a file type contains per-read
+// classifications that can be used by the mapping commands.
+func IsClassification(filetype string) bool {
+	return filetype == "kraken2" || filetype == "centrifuge" || filetype == "kaiju"
+}
+
+// readFormat detects the per-read output format from the first line.
+func readFormat(tsv []string) string {
+	switch {
+	case len(tsv) >= 5 && slices.Equal(tsv[:5], centrifuge_header[:5]):
+		return "centrifuge"
+	case len(tsv) == 5 && (tsv[0] == "C" || tsv[0] == "U"):
+		return "kraken2"
+	case len(tsv) >= 3 && (tsv[0] == "C" || tsv[0] == "U"):
+		return "kaiju"
+	}
+	return ""
+}
+
+// ReadScanner reads per-read classifications from Kraken2, KrakenUniq,
+// Centrifuge or Kaiju output and returns each read as a line of Kraken2
+// output.
+//
+// Centrifuge reports one line for every hit of a read. The hits are used like
+// k-mer assignments counting the hit length and the read is classified to the
+// lowest common ancestor of the hits. This requires a taxonomy with tree
+// queries, otherwise reads with several hits are classified to the first hit.
+// For Kaiju the taxa of all best matches are used like k-mer assignments,
+// which requires Kaiju's verbose output (`-v`).
+type ReadScanner struct {
+	scanner  *bufio.Scanner
+	taxonomy Ancestry
+	format   string
+	line     string
+	record   string
+	next     []string
+	err      error
+}
+
+// NewReadScanner creates a scanner for per-read classifications. The format
+// is detected from the first line. The taxonomy is only used for Centrifuge
+// output and may be nil.
+func NewReadScanner(reader io.Reader, taxonomy TaxonomyProvider) *ReadScanner {
+	s := &ReadScanner{scanner: bufio.NewScanner(reader)}
+	s.taxonomy, _ = taxonomy.(Ancestry)
+	return s
+}
+
+// Scan advances to the next read.
+func (s *ReadScanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	var fields []string
+	if s.next != nil {
+		fields, s.next = s.next, nil
+	} else {
+		if !s.scanner.Scan() {
+			return false
+		}
+		fields = strings.Split(s.scanner.Text(), "\t")
+	}
+	if s.format == "" {
+		s.format = readFormat(fields)
+		if s.format == "centrifuge" {
+			if !s.scanner.Scan() {
+				return false
+			}
+			fields = strings.Split(s.scanner.Text(), "\t")
+		}
+	}
+
+	switch s.format {
+	case "centrifuge":
+		s.err = s.centrifuge(fields)
+	case "kaiju":
+		s.err = s.kaiju(fields)
+	default:
+		s.line = s.scanner.Text()
+		s.record = s.line
+	}
+	return s.err == nil
+}
+
+// Text returns the current read as a line of Kraken2 output.
+func (s *ReadScanner) Text() string {
+	return s.line
+}
+
+// Record returns the lines of the input for the current read.
+func (s *ReadScanner) Record() string {
+	return s.record
+}
+
+func (s *ReadScanner) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.scanner.Err()
+}
+
+// centrifuge reads all hits of the read starting with the given line.
+func (s *ReadScanner) centrifuge(fields []string) error {
+	if len(fields) < len(centrifuge_header) {
+		return fmt.Errorf("malformed Centrifuge line: %s", strings.Join(fields, "\t"))
+	}
+	id := fields[0]
+	length := fields[6]
+	records := []string{strings.Join(fields, "\t")}
+	var taxids []int
+	var hits []string
+	for {
+		taxid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return fmt.Errorf("invalid taxon ID %s for read %s", fields[2], id)
+		}
+		if taxid != 0 {
+			taxids = append(taxids, taxid)
+			hits = append(hits, fields[2]+":"+fields[5])
+		}
+
+		if !s.scanner.Scan() {
+			break
+		}
+		fields = strings.Split(s.scanner.Text(), "\t")
+		if fields[0] != id {
+			s.next = fields
+			break
+		}
+		if len(fields) < len(centrifuge_header) {
+			return fmt.Errorf("malformed Centrifuge line: %s", s.scanner.Text())
+		}
+		records = append(records, s.scanner.Text())
+	}
+	s.record = strings.Join(records, "\n")
+
+	if len(taxids) == 0 {
+		s.line = "U\t" + id + "\t0\t" + length + "\t0:" + length
+		return nil
+	}
+	call := taxids[0]
+	if s.taxonomy != nil {
+		if lca, ok := s.taxonomy.LCA(taxids...); ok {
+			call = lca
+		}
+	}
+	s.line = "C\t" + id + "\t" + strconv.Itoa(call) + "\t" + length + "\t" + strings.Join(hits, " ")
+	return nil
+}
+
+// kaiju converts a line of Kaiju output.
+func (s *ReadScanner) kaiju(fields []string) error {
+	s.record = strings.Join(fields, "\t")
+	if len(fields) < 3 {
+		return fmt.Errorf("malformed Kaiju line: %s", s.record)
+	}
+	length := "0"
+	if len(fields) > 3 {
+		length = fields[3]
+	}
+	if fields[0] == "U" {
+		s.line = "U\t" + fields[1] + "\t0\t" + length + "\t0:" + length
+		return nil
+	}
+
+	var order []string
+	counts := make(map[string]int)
+	if len(fields) > 4 {
+		for _, taxid := range strings.Split(fields[4], ",") {
+			if taxid = strings.TrimSpace(taxid); taxid == "" {
+				continue
+			}
+			if counts[taxid] == 0 {
+				order = append(order, taxid)
+			}
+			counts[taxid]++
+		}
+	}
+	if len(order) == 0 {
+		order = []string{fields[2]}
+		counts[fields[2]] = 1
+	}
+	hits := make([]string, len(order))
+	for i, taxid := range order {
+		hits[i] = taxid + ":" + strconv.Itoa(counts[taxid])
+	}
+	s.line = "C\t" + fields[1] + "\t" + fields[2] + "\t" + length + "\t" + strings.Join(hits, " ")
+	return nil
+}
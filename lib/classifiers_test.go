@@ -0,0 +1,105 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var centrifuge = filepath.Join("..", "testdata", "centrifuge.tsv")
+var kaiju = filepath.Join("..", "testdata", "kaiju.out")
+
+func scanReads(t *testing.T, filename string, taxonomy TaxonomyProvider) ([]string, []string) {
+	file, err := OpenFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var lines, records []string
+	scanner := NewReadScanner(file, taxonomy)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		records = append(records, scanner.Record())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines, records
+}
+
+func TestCentrifuge(t *testing.T) {
+	if format, named := GetFormat(centrifuge); format != "centrifuge" || named {
+		t.Errorf("Expected format centrifuge but got %s.", format)
+	}
+	taxonomy := DetectTaxonomy(taxdump)
+	lines, records := scanReads(t, centrifuge, taxonomy)
+	expected := []string{
+		"C\tread1\t818\t150\t818:80",
+		"C\tread2\t816\t150\t817:61 818:61",
+		"C\tread3\t562\t150\t83333:75 562:26",
+		"U\tread4\t0\t150\t0:150",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected reads %v but got %v.", expected, lines)
+	}
+	if len(records) != 4 || strings.Count(records[1], "\n") != 1 {
+		t.Errorf("Expected two lines for read2 but got %v.", records)
+	}
+
+	// Without tree queries reads are classified to the first hit.
+	lines, _ = scanReads(t, centrifuge, nil)
+	if !strings.HasPrefix(lines[1], "C\tread2\t817\t") {
+		t.Errorf("Expected read2 to be classified to 817 but got %s.", lines[1])
+	}
+
+	k2map, err := SummarizeKmers(centrifuge, false, taxonomy)
+	if err != nil || k2map["816"].Classes["817"] != 61 || k2map["0"].Reads != 1 {
+		t.Errorf("Could not summarize the hits of Centrifuge output: %v", err)
+	}
+
+	out := filepath.Join(t.TempDir(), "filtered.tsv")
+	err = FilterReads(centrifuge, out, taxonomy, MustParseFormat("{K};{p};{c};{o};{f};{g};{s}"),
+		false, 0.9, 2, 10)
+	if err != nil {
+		t.Fatalf("Could not filter reads: %v", err)
+	}
+	if format, _ := GetFormat(out); format != "centrifuge" {
+		t.Errorf("Expected filtered output in Centrifuge format but got %s.", format)
+	}
+	filtered, _ := os.ReadFile(out)
+	if n := strings.Count(string(filtered), "\n"); n != 6 {
+		t.Errorf("Expected 6 lines of filtered output but got %d.", n)
+	}
+}
+
+func TestKaiju(t *testing.T) {
+	if format, named := GetFormat(kaiju); format != "kaiju" || named {
+		t.Errorf("Expected format kaiju but got %s.", format)
+	}
+	lines, records := scanReads(t, kaiju, nil)
+	expected := []string{
+		"C\tread1\t818\t61\t818:2",
+		"C\tread2\t816\t45\t817:1 818:2",
+		"U\tread3\t0\t0\t0:0",
+		"C\tread4\t562\t52\t562:1 83333:1",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected reads %v but got %v.", expected, lines)
+	}
+	if records[2] != "U\tread3\t0" {
+		t.Errorf("Expected the original record but got %s.", records[2])
+	}
+
+	scored := 0
+	_, err := scoreReads(kaiju, DetectTaxonomy(taxdump), MustParseFormat("{K};{p};{c};{o};{f};{g};{s}"), false,
+		func(line string, s *ReadScore) {
+			scored++
+			if s.ID == "read2" && (s.Kmers != 3 || s.Consistency != 1) {
+				t.Errorf("Expected 3 consistent matches for read2 but got %d with %g.", s.Kmers, s.Consistency)
+			}
+		})
+	if err != nil || scored != 3 {
+		t.Errorf("Expected 3 scored reads but got %d.", scored)
+	}
+}
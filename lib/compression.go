@@ -0,0 +1,187 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+)
+
+var (
+	gzip_magic  = []byte{0x1f, 0x8b}
+	zstd_magic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2_magic = []byte("BZh")
+)
+
+// StdStream is the file name that reads from stdin or writes to stdout.
+const StdStream = "-"
+
+var (
+	stdinOnce sync.Once
+	stdin     *bufio.Reader
+)
+
+// stdinReader returns the decompressed stdin. Stdin can only be read once, so
+// all readers share the same stream.
+func stdinReader() *bufio.Reader {
+	stdinOnce.Do(func() {
+		reader, err := Decompress(os.Stdin)
+		if err != nil {
+			log.Fatalf("could not read from stdin: %v", err)
+		}
+		stdin = bufio.NewReaderSize(reader, 1024*1024)
+	})
+	return stdin
+}
+
+// compressed wraps a (de)compressor and closes it before the underlying file.
+type compressed struct {
+	io.Reader
+	io.Writer
+	closers []io.Closer
+}
+
+func (c *compressed) Close() error {
+	var first error
+	for _, closer := range c.closers {
+		if err := closer.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+type closerFunc func()
+
+func (f closerFunc) Close() error {
+	f()
+	return nil
+}
+
+// Decompress detects gzip, zstd or bzip2 compressed data by its magic bytes
+// and returns a reader for the decompressed data. Uncompressed data is read
+// as is.
+func Decompress(reader io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReaderSize(reader, 64*1024)
+	magic, _ := buffered.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, gzip_magic):
+		gz, err := pgzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return &compressed{Reader: gz, closers: []io.Closer{gz}}, nil
+	case bytes.HasPrefix(magic, zstd_magic):
+		zr, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return &compressed{Reader: zr, closers: []io.Closer{closerFunc(zr.Close)}}, nil
+	case bytes.HasPrefix(magic, bzip2_magic):
+		return io.NopCloser(bzip2.NewReader(buffered)), nil
+	}
+	return io.NopCloser(buffered), nil
+}
+
+// OpenFile opens a file for reading and transparently decompresses it. The
+// path "-" reads from stdin.
+func OpenFile(path string) (io.ReadCloser, error) {
+	if path == StdStream {
+		return io.NopCloser(stdinReader()), nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := Decompress(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &compressed{Reader: reader, closers: []io.Closer{reader, file}}, nil
+}
+
+// CreateFile creates a file for writing. Files ending in `.gz` are written
+// with parallel gzip compression and files ending in `.zst` with zstd. The
+// path "-" writes uncompressed data to stdout.
+func CreateFile(path string) (io.WriteCloser, error) {
+	if path == StdStream {
+		return Compress(os.Stdout, path)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := Compress(file, path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &compressed{Writer: writer, closers: []io.Closer{writer, file}}, nil
+}
+
+// Compress wraps a writer with the compression indicated by the file name.
+// Closing the returned writer does not close the underlying writer.
+func Compress(writer io.Writer, name string) (io.WriteCloser, error) {
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		return pgzip.NewWriter(writer), nil
+	case strings.HasSuffix(name, ".zst"):
+		return zstd.NewWriter(writer)
+	}
+	return &compressed{Writer: writer}, nil
+}
+
+// Spool makes an input readable more than once. Stdin is copied to a
+// temporary file whose path is returned together with a function that removes
+// it. Other paths are returned as is.
+func Spool(path string) (string, func(), error) {
+	if path != StdStream {
+		return path, func() {}, nil
+	}
+	file, err := os.CreateTemp("", "architeuthis-*.zst")
+	if err != nil {
+		return "", nil, err
+	}
+	file.Close()
+	cleanup := func() { os.Remove(file.Name()) }
+
+	out, err := CreateFile(file.Name())
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	log.Printf("Buffering stdin in %s.", file.Name())
+	_, err = io.Copy(out, stdinReader())
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return file.Name(), cleanup, nil
+}
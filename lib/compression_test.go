@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	dir := t.TempDir()
+	lines, _ := CountLines(simple)
+	magics := map[string][]byte{
+		"test.k2.gz":  gzip_magic,
+		"test.k2.zst": zstd_magic,
+		"test.k2":     []byte("C\t"),
+	}
+	for name, magic := range magics {
+		out := filepath.Join(dir, name)
+		err := SimpleAppend([]string{simple}, out, false)
+		if err != nil {
+			t.Fatalf("Could not write %s: %v", name, err)
+		}
+		raw, _ := os.ReadFile(out)
+		if !bytes.HasPrefix(raw, magic) {
+			t.Errorf("Expected %s to start with %v but got %v.", name, magic, raw[:4])
+		}
+		if format, named := GetFormat(out); format != "kraken2" || named {
+			t.Errorf("Expected format kraken2 for %s but got %s.", name, format)
+		}
+		if n, _ := CountLines(out); n != lines {
+			t.Errorf("Expected %d lines in %s but got %d.", lines, name, n)
+		}
+	}
+
+	bz2 := filepath.Join("..", "testdata", "test.b2.bz2")
+	if format, _ := GetFormat(bz2); format != "bracken" {
+		t.Errorf("Expected format bracken for %s but got %s.", bz2, format)
+	}
+	counts, err := ReadCounts(bz2, "bracken", "new_est_reads")
+	if err != nil || len(counts.Values[counts.Samples[0]]) == 0 {
+		t.Errorf("Could not read counts from %s: %v", bz2, err)
+	}
+}
@@ -0,0 +1,469 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// bracken_levels are the Bracken level codes for taxonomic ranks.
+var bracken_levels = map[string]string{
+	"superkingdom": "D", "domain": "D", "kingdom": "K", "phylum": "P", "class": "C",
+	"order": "O", "family": "F", "genus": "G", "species": "S", "no rank": "R",
+}
+
+// Translator maps taxon IDs from a source taxonomy to a target taxonomy using
+// a crosswalk. Source taxa mapping to several target taxa are assigned to the
+// lowest common ancestor of those in the target taxonomy. Source taxa that are
+// not in the crosswalk are assigned to the lowest common ancestor of all their
+// descendants in the crosswalk.
+type Translator struct {
+	Source TaxonomyProvider
+	Target TaxonomyProvider
+	// Crosswalk maps source taxon IDs to target taxon IDs.
+	Crosswalk map[int][]int
+	// Unmapped counts the records for source taxa that could not be mapped.
+	Unmapped map[int]int
+
+	target  Ancestry
+	cache   map[int]int
+	derived map[int]int
+}
+
+// NewTranslator creates a translator. The target taxonomy must support LCA
+// queries.
+func NewTranslator(source TaxonomyProvider, target TaxonomyProvider, crosswalk map[int][]int) (*Translator, error) {
+	ancestry, ok := target.(Ancestry)
+	if !ok {
+		return nil, errors.New("the target taxonomy does not support LCA queries")
+	}
+	return &Translator{
+		Source:    source,
+		Target:    target,
+		Crosswalk: crosswalk,
+		Unmapped:  make(map[int]int),
+		target:    ancestry,
+		cache:     make(map[int]int),
+	}, nil
+}
+
+// crosswalkTaxids converts a crosswalk entry to taxon IDs. Entries can be
+// taxon IDs, names or lineages such as GTDB taxonomy strings, in which case
+// the last rank is looked up by name.
+func crosswalkTaxids(entry string, taxonomy TaxonomyProvider) ([]int, error) {
+	entry = strings.TrimSpace(entry)
+	if entry == "" || entry == "none" || entry == "NA" {
+		return nil, nil
+	}
+	if taxid, err := strconv.Atoi(entry); err == nil {
+		return []int{taxid}, nil
+	}
+	names, ok := taxonomy.(NameLookup)
+	if !ok {
+		return nil, fmt.Errorf("the taxonomy for `%s` does not support name lookups", entry)
+	}
+	ranks := strings.Split(strings.Trim(entry, "; "), ";")
+	name := stripPrefix(strings.TrimSpace(ranks[len(ranks)-1]))
+	return names.TaxidsByName(name), nil
+}
+
+// ReadCrosswalk reads a table mapping source to target taxa from the `from`
+// and `to` columns. Entries may be taxon IDs or names, which are looked up in
+// the respective taxonomy. Empty column names use the first and second
+// column. This reads GTDB metadata tables, for instance with the
+// `ncbi_taxid` and `gtdb_taxonomy` columns.
+func ReadCrosswalk(path string, from string, to string, source TaxonomyProvider,
+	target TaxonomyProvider) (map[int][]int, error) {
+	file, err := OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comma = '\t'
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	from_idx, to_idx := 0, 1
+	first, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	if from != "" || to != "" {
+		from_idx = slices.Index(first, from)
+		to_idx = slices.Index(first, to)
+		if from_idx < 0 || to_idx < 0 {
+			return nil, fmt.Errorf("crosswalk %s needs the columns `%s` and `%s`", path, from, to)
+		}
+		first = nil
+	}
+
+	crosswalk := make(map[int][]int)
+	unresolved := 0
+	for record := first; ; record, err = reader.Read() {
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			continue
+		}
+		if len(record) <= max(from_idx, to_idx) {
+			return nil, fmt.Errorf("crosswalk %s has too few columns in `%s`", path,
+				strings.Join(record, "\t"))
+		}
+		sources, err := crosswalkTaxids(record[from_idx], source)
+		if err != nil {
+			return nil, err
+		}
+		targets, err := crosswalkTaxids(record[to_idx], target)
+		if err != nil {
+			return nil, err
+		}
+		if len(sources) == 0 || len(targets) == 0 {
+			unresolved++
+			continue
+		}
+		for _, s := range sources {
+			for _, t := range targets {
+				if !slices.Contains(crosswalk[s], t) {
+					crosswalk[s] = append(crosswalk[s], t)
+				}
+			}
+		}
+	}
+	if unresolved > 0 {
+		log.Printf("%d crosswalk entries could not be resolved and were skipped.", unresolved)
+	}
+	if len(crosswalk) == 0 {
+		return nil, fmt.Errorf("crosswalk %s does not contain any usable entries", path)
+	}
+
+	return crosswalk, nil
+}
+
+// deriveAncestors assigns all ancestors of crosswalk taxa to the lowest common
+// ancestor of the targets of their descendants. Sources that are not trees are
+// only queried once for the subtree of the crosswalk taxa.
+func (tr *Translator) deriveAncestors() {
+	tr.derived = make(map[int]int)
+	source := tr.Source
+	if _, ok := source.(TreeTaxonomy); !ok {
+		taxids := make([]int, 0, len(tr.Crosswalk))
+		for s := range tr.Crosswalk {
+			taxids = append(taxids, s)
+		}
+		if tree, err := Subtree(tr.Source, taxids); err == nil {
+			source = tree
+		}
+	}
+	for s := range tr.Crosswalk {
+		lca, ok := tr.target.LCA(tr.Crosswalk[s]...)
+		if !ok {
+			continue
+		}
+		for taxid, ok := source.Parent(s); ok; taxid, ok = source.Parent(taxid) {
+			if _, direct := tr.Crosswalk[taxid]; direct {
+				continue
+			}
+			if prev, seen := tr.derived[taxid]; seen {
+				lca, _ = tr.target.LCA(prev, lca)
+			}
+			tr.derived[taxid] = lca
+		}
+	}
+}
+
+// Translate maps a source taxon ID to the target taxonomy. This is false if
+// the taxon could not be mapped.
+func (tr *Translator) Translate(taxid int) (int, bool) {
+	if t, ok := tr.cache[taxid]; ok {
+		return t, t > 0
+	}
+	result := 0
+	if targets, ok := tr.Crosswalk[taxid]; ok {
+		result, _ = tr.target.LCA(targets...)
+	} else {
+		if tr.derived == nil {
+			tr.deriveAncestors()
+		}
+		current := taxid
+		lin, err := tr.Source.Lineages([]string{strconv.Itoa(taxid)}, "")
+		if err == nil && lin[strconv.Itoa(taxid)].Taxid != "" {
+			current, _ = strconv.Atoi(lin[strconv.Itoa(taxid)].Taxid)
+		}
+		if targets, ok := tr.Crosswalk[current]; ok {
+			result, _ = tr.target.LCA(targets...)
+		} else {
+			result = tr.derived[current]
+		}
+	}
+	tr.cache[taxid] = result
+	return result, result > 0
+}
+
+// LogUnmapped reports the number of records for unmapped taxa.
+func (tr *Translator) LogUnmapped(unit string) {
+	total := 0
+	for _, n := range tr.Unmapped {
+		total += n
+	}
+	if total > 0 {
+		log.Printf("%d %s from %d taxa could not be mapped to the target taxonomy.",
+			total, unit, len(tr.Unmapped))
+	}
+}
+
+// SaveUnmapped writes the unmapped source taxa and their record counts.
+func (tr *Translator) SaveUnmapped(path string) (err error) {
+	file, err := CreateFile(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	taxids := make([]int, 0, len(tr.Unmapped))
+	for taxid := range tr.Unmapped {
+		taxids = append(taxids, taxid)
+	}
+	sort.Ints(taxids)
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"taxid", "name", "records"})
+	for _, taxid := range taxids {
+		name, _ := tr.Source.Name(taxid)
+		writer.Write([]string{strconv.Itoa(taxid), name, strconv.Itoa(tr.Unmapped[taxid])})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// TranslateKraken rewrites the read and k-mer classifications of a Kraken2
+// output. Reads classified to unmapped taxa become unclassified and k-mers of
+// unmapped taxa are assigned to taxon 0.
+func TranslateKraken(k2path string, out string, tr *Translator, named bool) (err error) {
+	k2file, err := OpenFile(k2path)
+	if err != nil {
+		return err
+	}
+	defer k2file.Close()
+	outfile, err := CreateFile(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := outfile.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	writer := bufio.NewWriter(outfile)
+
+	reads := 0
+	scanner := bufio.NewScanner(k2file)
+	for scanner.Scan() {
+		tokens := strings.Split(strings.Trim(scanner.Text(), " "), "\t")
+		if len(tokens) < 5 {
+			return fmt.Errorf("malformed line in %s: %s", k2path, scanner.Text())
+		}
+		reads++
+		if tokens[0] == "C" {
+			taxid, err := strconv.Atoi(TaxID(tokens[2], named))
+			if err != nil {
+				return fmt.Errorf("invalid taxon ID %s in %s", tokens[2], k2path)
+			}
+			target, ok := tr.Translate(taxid)
+			if !ok {
+				tr.Unmapped[taxid]++
+				tokens[0] = "U"
+			}
+			tokens[2] = strconv.Itoa(target)
+			if named {
+				name, _ := tr.Target.Name(target)
+				if !ok {
+					name = "unclassified"
+				}
+				tokens[2] = fmt.Sprintf("%s (taxid %d)", name, target)
+			}
+		}
+
+		kmers := strings.Fields(tokens[4])
+		for i, s := range kmers {
+			splits := strings.SplitN(s, ":", 2)
+			if len(splits) != 2 || splits[0] == "|" || splits[0] == "A" || splits[0] == "0" {
+				continue
+			}
+			taxid, err := strconv.Atoi(splits[0])
+			if err != nil {
+				return fmt.Errorf("invalid k-mer assignment %s in %s", s, k2path)
+			}
+			target, _ := tr.Translate(taxid)
+			kmers[i] = strconv.Itoa(target) + ":" + splits[1]
+		}
+		tokens[4] = strings.Join(kmers, " ")
+
+		writer.WriteString(strings.Join(tokens, "\t"))
+		writer.WriteByte('\n')
+		if reads%1e6 == 0 {
+			log.Printf("Processed %d reads...", reads)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	log.Printf("Translated %d reads - Done.", reads)
+	tr.LogUnmapped("reads")
+
+	return writer.Flush()
+}
+
+// TranslateBracken rewrites a Bracken output ("bracken") or a merged Bracken
+// table ("bracken-merged"). Rows mapping to the same target taxon are summed
+// and fractions are recalculated. Rows for unmapped taxa are dropped.
+func TranslateBracken(path string, out string, filetype string, tr *Translator) (err error) {
+	file, err := OpenFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	sep := ','
+	if filetype == "bracken" {
+		sep = '\t'
+	}
+	reader.Comma = sep
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	col := func(name string) int {
+		return slices.Index(header, name)
+	}
+	sample_idx := col("sample_id")
+	name_idx, tid_idx, lvl_idx, frac_idx := col("name"), col("taxonomy_id"), col("taxonomy_lvl"),
+		col("fraction_total_reads")
+	count_idx := []int{col("kraken_assigned_reads"), col("added_reads"), col("new_est_reads")}
+	if slices.Contains(count_idx, -1) || tid_idx < 0 || name_idx < 0 || lvl_idx < 0 || frac_idx < 0 {
+		return fmt.Errorf("%s is not a Bracken file", path)
+	}
+
+	type row struct {
+		sample string
+		taxid  int
+		counts []float64
+	}
+	var rows []*row
+	index := make(map[string]*row)
+	records := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		records++
+		sample := ""
+		if sample_idx >= 0 {
+			sample = record[sample_idx]
+		}
+		taxid, err := strconv.Atoi(record[tid_idx])
+		if err != nil {
+			return fmt.Errorf("invalid taxon ID %s in %s", record[tid_idx], path)
+		}
+		target, ok := tr.Translate(taxid)
+		if !ok {
+			tr.Unmapped[taxid]++
+			continue
+		}
+		key := sample + "\t" + strconv.Itoa(target)
+		r, ok := index[key]
+		if !ok {
+			r = &row{sample: sample, taxid: target, counts: make([]float64, len(count_idx))}
+			index[key] = r
+			rows = append(rows, r)
+		}
+		for i, idx := range count_idx {
+			v, err := strconv.ParseFloat(record[idx], 64)
+			if err != nil {
+				return fmt.Errorf("invalid count %s in %s", record[idx], path)
+			}
+			r.counts[i] += v
+		}
+	}
+	totals := make(map[string]float64)
+	for _, r := range rows {
+		totals[r.sample] += r.counts[2]
+	}
+
+	outfile, err := CreateFile(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := outfile.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	// Other columns such as lineages refer to the source taxa and are dropped.
+	writer := csv.NewWriter(outfile)
+	writer.Comma = sep
+	if sample_idx >= 0 {
+		writer.Write(append([]string{"sample_id"}, bracken_header...))
+	} else {
+		writer.Write(bracken_header)
+	}
+	for _, r := range rows {
+		name, _ := tr.Target.Name(r.taxid)
+		rank, _ := tr.Target.Rank(r.taxid)
+		level := bracken_levels[rank]
+		if level == "" {
+			level = "-"
+		}
+		record := []string{name, strconv.Itoa(r.taxid), level}
+		for _, v := range r.counts {
+			record = append(record, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+		frac := 0.0
+		if totals[r.sample] > 0 {
+			frac = r.counts[2] / totals[r.sample]
+		}
+		record = append(record, strconv.FormatFloat(frac, 'f', 5, 64))
+		if sample_idx >= 0 {
+			record = append([]string{r.sample}, record...)
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	log.Printf("Translated %d records into %d records - Done.", records, len(rows))
+	tr.LogUnmapped("records")
+
+	return writer.Error()
+}
@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	source := DetectTaxonomy(taxdump)
+	target := DetectTaxonomy(filepath.Join("..", "testdata", "gtdb"))
+	crosswalk, err := ReadCrosswalk(filepath.Join("..", "testdata", "crosswalk.tsv"),
+		"ncbi_taxid", "gtdb_taxonomy", source, target)
+	if err != nil {
+		t.Fatalf("Could not read the crosswalk: %v", err)
+	}
+	if _, ok := crosswalk[817]; ok {
+		t.Error("Unknown target names should be skipped.")
+	}
+	tr, err := NewTranslator(source, target, crosswalk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[int]int{820: 5539, 818: 318, 46506: 88, 816: 88, 562: 8, 83333: 0, 821: 0}
+	for taxid, e := range expected {
+		if got, ok := tr.Translate(taxid); got != e || ok != (e > 0) {
+			t.Errorf("Expected %d to translate to %d but got %d.", taxid, e, got)
+		}
+	}
+	if got, _ := tr.Translate(1000002); got != 8 {
+		t.Errorf("Merged taxa should be resolved but got %d.", got)
+	}
+
+	in := filepath.Join(t.TempDir(), "in.k2")
+	out := filepath.Join(t.TempDir(), "out.k2")
+	os.WriteFile(in, []byte("C\tr1\t820\t100\t820:10 0:5 816:3 |:| 821:2\n"+
+		"C\tr2\t821\t100\t821:12\nU\tr3\t0\t100\t0:12\n"), 0o644)
+	if err := TranslateKraken(in, out, tr, false); err != nil {
+		t.Fatalf("Could not translate: %v", err)
+	}
+	data, _ := os.ReadFile(out)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if lines[0] != "C\tr1\t5539\t100\t5539:10 0:5 88:3 |:| 0:2" {
+		t.Errorf("Got wrong translation %q.", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "U\tr2\t0\t") {
+		t.Errorf("Unmapped reads should be unclassified but got %q.", lines[1])
+	}
+	if tr.Unmapped[821] != 1 {
+		t.Errorf("Expected %d unmapped read for 821 but got %d.", 1, tr.Unmapped[821])
+	}
+	in = filepath.Join(t.TempDir(), "in.csv")
+	out = filepath.Join(t.TempDir(), "out.csv")
+	os.WriteFile(in, []byte("sample_id,name,taxonomy_id,taxonomy_lvl,kraken_assigned_reads,"+
+		"added_reads,new_est_reads,fraction_total_reads,lineage,taxid_lineage,remapped_taxid\n"+
+		"A,Bacteroides uniformis,820,S,10,5,15,1.0,s__Bacteroides uniformis,820,820\n"), 0o644)
+	if err := TranslateBracken(in, out, "bracken-merged", tr); err != nil {
+		t.Fatalf("Could not translate: %v", err)
+	}
+	if format, lineage := GetFormat(out); format != "bracken-merged" || lineage {
+		t.Errorf("Expected a merged Bracken file without lineages but got %s (%v).", format, lineage)
+	}
+	data, _ = os.ReadFile(out)
+	lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], "A,") || !strings.HasSuffix(lines[1], ",5539,S,10,5,15,1.00000") {
+		t.Errorf("Got wrong translation %q.", lines)
+	}
+}
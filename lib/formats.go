@@ -2,8 +2,8 @@ package lib
 
 import (
 	"bufio"
+	"bytes"
 	"log"
-	"os"
 	"slices"
 	"strconv"
 	"strings"
@@ -15,23 +15,46 @@ var bracken_header = []string{"name", "taxonomy_id", "taxonomy_lvl",
 
 var mappings_header = []string{"sample_id", "classification"}
 
-func GetFormat(filename string) (string, bool) {
-	file, err := os.Open(filename)
+// firstLine reads the first line of a file. For stdin the line is only
+// peeked at, so it can still be read afterwards.
+func firstLine(filename string) (string, bool) {
+	if filename == StdStream {
+		reader := stdinReader()
+		for n := 4096; ; n *= 2 {
+			n = min(n, reader.Size())
+			data, err := reader.Peek(n)
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				return strings.TrimSuffix(string(data[:i]), "\r"), true
+			}
+			if err != nil || n == reader.Size() {
+				return string(data), len(data) > 0
+			}
+		}
+	}
+
+	file, err := OpenFile(filename)
 	if err != nil {
 		log.Fatalf("could not open file %s: %s", filename, err)
 	}
-
 	defer file.Close()
 	scanner := bufio.NewScanner(file)
 	if !scanner.Scan() {
-		log.Fatalf("file %s does not contain a single line", filename)
+		if err := scanner.Err(); err != nil {
+			log.Fatalf("could not read from file %s: %s", filename, err)
+		}
+		return "", false
 	}
-	if err != nil {
-		log.Fatalf("could not read from file %s: %s", filename, err)
+	return scanner.Text(), true
+}
+
+func GetFormat(filename string) (string, bool) {
+	line, ok := firstLine(filename)
+	if !ok {
+		log.Fatalf("file %s does not contain a single line", filename)
 	}
 
-	tsv := strings.Split(scanner.Text(), "\t")
-	csv := strings.Split(scanner.Text(), ",")
+	tsv := strings.Split(line, "\t")
+	csv := strings.Split(line, ",")
 
 	has_lineage := slices.Contains(csv, "lineage") && slices.Contains(csv, "taxid_lineage")
 	if ((tsv[0] == "C") || (tsv[0] == "U")) && len(tsv) == 5 {
@@ -41,10 +64,27 @@ func GetFormat(filename string) (string, bool) {
 		return "kraken2", true
 	}
 
-	if (len(tsv) == 6) && (tsv[3] == "U") && (tsv[5] == "unclassified") {
+	switch readFormat(tsv) {
+	case "centrifuge":
+		return "centrifuge", false
+	case "kaiju":
+		if _, err := strconv.Atoi(tsv[2]); err == nil {
+			return "kaiju", false
+		}
+	}
+
+	if isMPALine(tsv) {
+		return "mpa", false
+	}
+
+	if isReportLine(tsv) {
 		return "report", has_lineage
 	}
 
+	if strings.HasPrefix(line, "# KrakenUniq") || (len(tsv) == 9 && tsv[0] == "%" && tsv[2] == "taxReads") {
+		return "krakenuniq-report", has_lineage
+	}
+
 	if (len(tsv) >= 7) && (slices.Compare(tsv[0:7], bracken_header) == 0) {
 		return "bracken", has_lineage
 	}
@@ -63,3 +103,33 @@ func GetFormat(filename string) (string, bool) {
 
 	return "", has_lineage
 }
+
+// IsReport checks whether a file type is a Kraken2 or KrakenUniq report.
+func IsReport(filetype string) bool {
+	return filetype == "report" || filetype == "krakenuniq-report"
+}
+
+// isReportLine checks whether the first line of a file is from a Kraken2
+// report. This is the unclassified line or the root if there are no
+// unclassified reads.
+func isReportLine(tsv []string) bool {
+	if len(tsv) != 6 && len(tsv) != 8 {
+		return false
+	}
+	if _, err := strconv.ParseFloat(strings.TrimSpace(tsv[0]), 64); err != nil {
+		return false
+	}
+	n := len(tsv)
+	code, name := tsv[n-3], strings.TrimSpace(tsv[n-1])
+	return (code == "U" && name == "unclassified") || (code == "R" && name == "root")
+}
+
+// isMPALine checks whether the first line of a file is from a MetaPhlAn-style
+// table. This is either a header or a clade such as `k__Bacteria`.
+func isMPALine(tsv []string) bool {
+	if tsv[0] == "#Classification" || tsv[0] == "#clade_name" || strings.HasPrefix(tsv[0], "#mpa_") {
+		return true
+	}
+	prefix, _, found := strings.Cut(tsv[0], "__")
+	return found && len(tsv) >= 2 && len(prefix) == 1 && !strings.Contains(tsv[0], ",")
+}
@@ -0,0 +1,181 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// GTDBFormat is the default lineage format for GTDB taxonomies.
+const GTDBFormat = "{d};{p};{c};{o};{f};{g};{s}"
+
+// GTDBTaxidFile is the taxon ID mapping written by gtdb_to_taxdump.
+const GTDBTaxidFile = "taxID_info.tsv"
+
+var gtdbRanks = map[string]string{
+	"d": "domain", "p": "phylum", "c": "class", "o": "order",
+	"f": "family", "g": "genus", "s": "species",
+}
+
+// GTDBFiles returns the GTDB taxonomy tables (`bac120_taxonomy*.tsv` and
+// `ar53_taxonomy*.tsv`) and the taxon ID mapping in a directory.
+func GTDBFiles(data_dir string) ([]string, string) {
+	var tables []string
+	for _, pattern := range []string{"bac120_taxonomy*.tsv", "ar53_taxonomy*.tsv", "ar122_taxonomy*.tsv"} {
+		matches, _ := filepath.Glob(filepath.Join(data_dir, pattern))
+		tables = append(tables, matches...)
+	}
+	mapping := filepath.Join(data_dir, GTDBTaxidFile)
+	if _, err := os.Stat(mapping); err != nil {
+		mapping = ""
+	}
+	return tables, mapping
+}
+
+// IsGTDB checks whether a directory contains a GTDB taxonomy.
+func IsGTDB(data_dir string) bool {
+	tables, mapping := GTDBFiles(data_dir)
+	return len(tables) > 0 && mapping != ""
+}
+
+// stripPrefix removes a GTDB rank prefix such as `g__` from a name.
+func stripPrefix(name string) string {
+	if len(name) > 3 && name[1:3] == "__" {
+		return name[3:]
+	}
+	return name
+}
+
+// readGTDBTaxids reads the mapping of GTDB names to taxon IDs.
+func readGTDBTaxids(path string) (map[string]int, error) {
+	file, err := OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("taxon ID mapping %s is empty", path)
+	}
+	header := strings.Split(scanner.Text(), "\t")
+	name_idx := slices.IndexFunc(header, func(s string) bool {
+		return s == "tax_name" || s == "name"
+	})
+	id_idx := slices.IndexFunc(header, func(s string) bool {
+		return s == "tax_id" || s == "taxid" || s == "taxonomy_id"
+	})
+	if name_idx < 0 || id_idx < 0 {
+		return nil, fmt.Errorf("taxon ID mapping %s needs `tax_name` and `tax_id` columns", path)
+	}
+
+	taxids := make(map[string]int, 1e5)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) <= max(name_idx, id_idx) {
+			continue
+		}
+		taxid, err := strconv.Atoi(fields[id_idx])
+		if err != nil {
+			return nil, fmt.Errorf("invalid taxon ID %s in %s", fields[id_idx], path)
+		}
+		taxids[stripPrefix(fields[name_idx])] = taxid
+	}
+
+	return taxids, scanner.Err()
+}
+
+// LoadGTDB reads a GTDB taxonomy from the GTDB taxonomy tables in `data_dir`.
+// Taxon IDs are assigned from the `taxID_info.tsv` mapping generated by
+// gtdb_to_taxdump, so they match Kraken2 databases built from it.
+func LoadGTDB(data_dir string) (*Tree, error) {
+	tables, mapping := GTDBFiles(data_dir)
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("no GTDB taxonomy tables found in %s", data_dir)
+	}
+	if mapping == "" {
+		return nil, fmt.Errorf("no %s found in %s", GTDBTaxidFile, data_dir)
+	}
+	taxids, err := readGTDBTaxids(mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &Node{Taxid: 1, Name: "root", Rank: "no rank"}
+	tree := &Tree{
+		Root:    root,
+		Taxids:  map[int]*Node{1: root},
+		Merged:  make(map[int]int),
+		Deleted: make(map[int]bool),
+	}
+	unmapped := make(map[string]bool)
+	for _, table := range tables {
+		file, err := OpenFile(table)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Split(scanner.Text(), "\t")
+			if len(fields) < 2 {
+				continue
+			}
+			parent := root
+			for _, taxon := range strings.Split(fields[1], ";") {
+				rank, ok := gtdbRanks[strings.SplitN(taxon, "__", 2)[0]]
+				name := stripPrefix(taxon)
+				if !ok || name == taxon {
+					file.Close()
+					return nil, fmt.Errorf("invalid GTDB lineage %s in %s", fields[1], table)
+				}
+				taxid, ok := taxids[name]
+				if !ok {
+					unmapped[taxon] = true
+					continue
+				}
+				node, ok := tree.Taxids[taxid]
+				if !ok {
+					node = &Node{Taxid: taxid, Name: name, Rank: rank, Parent: parent}
+					parent.Children = append(parent.Children, node)
+					tree.Taxids[taxid] = node
+				}
+				parent = node
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(tree.Taxids) == 1 {
+		return nil, errors.New("none of the GTDB taxa had a taxon ID")
+	}
+	if len(unmapped) > 0 {
+		log.Printf("%d GTDB taxa had no taxon ID in %s and were skipped.",
+			len(unmapped), mapping)
+	}
+
+	return tree, nil
+}
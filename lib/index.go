@@ -0,0 +1,526 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const indexMagic = "ARCHTAXI"
+
+// IndexVersion is the version of the on-disk index format. Indices with a
+// different version are ignored and rebuilt.
+const IndexVersion uint32 = 2
+
+// TaxonomyIndex is a compact representation of a taxonomy with precomputed
+// lineages for a single lineage format. Nodes are sorted by taxon ID.
+type TaxonomyIndex struct {
+	Checksum string
+	Format   string
+	// The ranks of the format, parsed once when the index is built or loaded.
+	FormatRanks []string
+	Taxids      []uint32
+	Parents     []uint32
+	Ranks       []uint16
+	RankNames   []string
+	Names       []string
+	// Position+1 of the ancestor on each rank of the format, 0 if missing.
+	Lineages []uint32
+	// Merged taxon IDs sorted by the old ID and sorted deleted taxon IDs.
+	MergedFrom []uint32
+	MergedTo   []uint32
+	Deleted    []uint32
+
+	// Children in compressed form, built on first use.
+	childOnce   sync.Once
+	childStart  []uint32
+	childOrders []uint32
+
+	// Tree query structure, built on first use.
+	ancOnce sync.Once
+	anc     *ancestry
+
+	// Positions by lowercase name, built on first use.
+	nameOnce sync.Once
+	byName   map[string][]uint32
+}
+
+var loadedIndices = struct {
+	sync.Mutex
+	m map[string]*TaxonomyIndex
+}{m: make(map[string]*TaxonomyIndex)}
+
+// IndexDir returns the directory for cached taxonomy indices. This is
+// `$ARCHITEUTHIS_CACHE` if set or the user cache directory otherwise.
+func IndexDir() string {
+	if dir := os.Getenv("ARCHITEUTHIS_CACHE"); dir != "" {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "architeuthis")
+}
+
+// IndexPath returns the cache path for the index of a taxonomy checksum and
+// format or an empty string if there is no cache directory.
+func IndexPath(checksum string, format string) string {
+	dir := IndexDir()
+	if dir == "" {
+		return ""
+	}
+	key := sha256.Sum256([]byte(checksum + "\n" + format))
+	return filepath.Join(dir, hex.EncodeToString(key[:8])+".idx")
+}
+
+// BuildIndex converts a taxonomy tree into an index for the given format.
+func BuildIndex(tree *Tree, format string) *TaxonomyIndex {
+	n := len(tree.Taxids)
+	idx := &TaxonomyIndex{
+		Format:      format,
+		FormatRanks: GetRanks(format),
+		Taxids:      make([]uint32, 0, n),
+		Parents:     make([]uint32, n),
+		Ranks:       make([]uint16, n),
+		Names:       make([]string, n),
+	}
+	for taxid := range tree.Taxids {
+		idx.Taxids = append(idx.Taxids, uint32(taxid))
+	}
+	slices.Sort(idx.Taxids)
+
+	rank_ids := make(map[string]uint16)
+	for i, taxid := range idx.Taxids {
+		node := tree.Taxids[int(taxid)]
+		rid, ok := rank_ids[node.Rank]
+		if !ok {
+			rid = uint16(len(idx.RankNames))
+			rank_ids[node.Rank] = rid
+			idx.RankNames = append(idx.RankNames, node.Rank)
+		}
+		idx.Ranks[i] = rid
+		idx.Names[i] = node.Name
+		if node.Parent == nil {
+			idx.Parents[i] = uint32(i)
+		} else {
+			idx.Parents[i] = uint32(idx.position(node.Parent.Taxid))
+		}
+	}
+
+	// Lineages are inherited from the parent so we visit nodes top-down.
+	ranks := idx.FormatRanks
+	k := len(ranks)
+	idx.Lineages = make([]uint32, n*k)
+	queue := []*Node{}
+	if tree.Root != nil {
+		queue = append(queue, tree.Root)
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		i := idx.position(node.Taxid)
+		lin := idx.Lineages[i*k : (i+1)*k]
+		if node.Parent != nil {
+			p := idx.Parents[i]
+			copy(lin, idx.Lineages[int(p)*k:int(p+1)*k])
+		}
+		for j, r := range ranks {
+			if slices.Contains(RankSymbols[r], node.Rank) {
+				lin[j] = uint32(i + 1)
+			}
+		}
+		queue = append(queue, node.Children...)
+	}
+
+	for old := range tree.Merged {
+		idx.MergedFrom = append(idx.MergedFrom, uint32(old))
+	}
+	slices.Sort(idx.MergedFrom)
+	idx.MergedTo = make([]uint32, len(idx.MergedFrom))
+	for i, old := range idx.MergedFrom {
+		idx.MergedTo[i] = uint32(tree.Merged[int(old)])
+	}
+	for taxid := range tree.Deleted {
+		idx.Deleted = append(idx.Deleted, uint32(taxid))
+	}
+	slices.Sort(idx.Deleted)
+
+	return idx
+}
+
+// position returns the index of a taxon ID or -1 if it is not in the index.
+func (idx *TaxonomyIndex) position(taxid int) int {
+	if taxid < 0 {
+		return -1
+	}
+	i, found := slices.BinarySearch(idx.Taxids, uint32(taxid))
+	if !found {
+		return -1
+	}
+	return i
+}
+
+// Resolve returns the position of a taxon ID in the index. Merged taxon IDs
+// are replaced by the ID they were merged into.
+func (idx *TaxonomyIndex) Resolve(taxid int) (int, bool) {
+	if taxid < 0 {
+		return -1, false
+	}
+	merged := false
+	if i, found := slices.BinarySearch(idx.MergedFrom, uint32(taxid)); found {
+		taxid = int(idx.MergedTo[i])
+		merged = true
+	}
+	return idx.position(taxid), merged
+}
+
+// IsDeleted checks whether a taxon ID was deleted from the taxonomy.
+func (idx *TaxonomyIndex) IsDeleted(taxid int) bool {
+	_, found := slices.BinarySearch(idx.Deleted, uint32(taxid))
+	return taxid >= 0 && found
+}
+
+// Lineage returns the lineage of a taxon on the ranks of the index format.
+func (idx *TaxonomyIndex) Lineage(taxid string) *Lineage {
+	ranks := idx.FormatRanks
+	lin := emptyLineage(ranks)
+	tid, err := strconv.Atoi(taxid)
+	if err != nil {
+		return lin
+	}
+	lin.Deleted = idx.IsDeleted(tid)
+	pos, merged := idx.Resolve(tid)
+	lin.Merged = merged
+	if pos < 0 {
+		return lin
+	}
+	lin.Taxid = strconv.Itoa(int(idx.Taxids[pos]))
+	for i, anc := range idx.Lineages[pos*len(ranks) : (pos+1)*len(ranks)] {
+		if anc > 0 {
+			lin.Names[i] += idx.Names[anc-1]
+			lin.Taxids[i] = strconv.Itoa(int(idx.Taxids[anc-1]))
+			lin.Present[i] = true
+		}
+	}
+	return lin
+}
+
+// Parent returns the taxon ID of the parent.
+func (idx *TaxonomyIndex) Parent(taxid int) (int, bool) {
+	pos, _ := idx.Resolve(taxid)
+	if pos < 0 || idx.Parents[pos] == uint32(pos) {
+		return 0, false
+	}
+	return int(idx.Taxids[idx.Parents[pos]]), true
+}
+
+// Name returns the scientific name of a taxon.
+func (idx *TaxonomyIndex) Name(taxid int) (string, bool) {
+	pos, _ := idx.Resolve(taxid)
+	if pos < 0 {
+		return "", false
+	}
+	return idx.Names[pos], true
+}
+
+// Rank returns the rank of a taxon.
+func (idx *TaxonomyIndex) Rank(taxid int) (string, bool) {
+	pos, _ := idx.Resolve(taxid)
+	if pos < 0 {
+		return "", false
+	}
+	return idx.RankNames[idx.Ranks[pos]], true
+}
+
+// Children returns the taxon IDs of the direct children of a taxon.
+func (idx *TaxonomyIndex) Children(taxid int) []int {
+	pos, _ := idx.Resolve(taxid)
+	if pos < 0 {
+		return nil
+	}
+	idx.childOnce.Do(func() {
+		n := len(idx.Taxids)
+		idx.childStart = make([]uint32, n+1)
+		for i, p := range idx.Parents {
+			if p != uint32(i) {
+				idx.childStart[p+1]++
+			}
+		}
+		for i := 0; i < n; i++ {
+			idx.childStart[i+1] += idx.childStart[i]
+		}
+		idx.childOrders = make([]uint32, idx.childStart[n])
+		fill := slices.Clone(idx.childStart[:n])
+		for i, p := range idx.Parents {
+			if p != uint32(i) {
+				idx.childOrders[fill[p]] = uint32(i)
+				fill[p]++
+			}
+		}
+	})
+	children := make([]int, 0, idx.childStart[pos+1]-idx.childStart[pos])
+	for _, c := range idx.childOrders[idx.childStart[pos]:idx.childStart[pos+1]] {
+		children = append(children, int(idx.Taxids[c]))
+	}
+	return children
+}
+
+// TaxidsByName returns the taxon IDs with the given name ignoring case.
+func (idx *TaxonomyIndex) TaxidsByName(name string) []int {
+	idx.nameOnce.Do(func() {
+		idx.byName = make(map[string][]uint32, len(idx.Names))
+		for i, n := range idx.Names {
+			key := strings.ToLower(n)
+			idx.byName[key] = append(idx.byName[key], uint32(i))
+		}
+	})
+	var taxids []int
+	for _, pos := range idx.byName[strings.ToLower(strings.TrimSpace(name))] {
+		taxids = append(taxids, int(idx.Taxids[pos]))
+	}
+	return taxids
+}
+
+// Tree rebuilds the full taxonomy tree from the index.
+func (idx *TaxonomyIndex) Tree() *Tree {
+	tree := &Tree{
+		Taxids:  make(map[int]*Node, len(idx.Taxids)),
+		Merged:  make(map[int]int, len(idx.MergedFrom)),
+		Deleted: make(map[int]bool, len(idx.Deleted)),
+	}
+	for i, old := range idx.MergedFrom {
+		tree.Merged[int(old)] = int(idx.MergedTo[i])
+	}
+	for _, taxid := range idx.Deleted {
+		tree.Deleted[int(taxid)] = true
+	}
+	nodes := make([]*Node, len(idx.Taxids))
+	for i, taxid := range idx.Taxids {
+		nodes[i] = &Node{Taxid: int(taxid), Name: idx.Names[i], Rank: idx.RankNames[idx.Ranks[i]]}
+		tree.Taxids[int(taxid)] = nodes[i]
+	}
+	for i, node := range nodes {
+		if idx.Parents[i] == uint32(i) {
+			tree.Root = node
+			continue
+		}
+		node.Parent = nodes[idx.Parents[i]]
+		node.Parent.Children = append(node.Parent.Children, node)
+	}
+	return tree
+}
+
+func writeString(w io.Writer, s string) error {
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+func readString(r io.Reader) (string, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	return string(buf), err
+}
+
+// Save writes the index to a file. The file is replaced atomically so
+// concurrent runs never see a partial index.
+func (idx *TaxonomyIndex) Save(path string) error {
+	file, err := os.CreateTemp(filepath.Dir(path), ".index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	le := binary.LittleEndian
+
+	names := make([]uint32, len(idx.Names)+1)
+	for i, name := range idx.Names {
+		names[i+1] = names[i] + uint32(len(name))
+	}
+	header := []uint32{IndexVersion, uint32(len(idx.Taxids)),
+		uint32(len(idx.Lineages)), uint32(len(idx.RankNames)),
+		uint32(len(idx.MergedFrom)), uint32(len(idx.Deleted))}
+
+	writer.WriteString(indexMagic)
+	binary.Write(writer, le, header)
+	writeString(writer, idx.Checksum)
+	writeString(writer, idx.Format)
+	for _, r := range idx.RankNames {
+		writeString(writer, r)
+	}
+	for _, data := range []any{idx.Taxids, idx.Parents, idx.Ranks, idx.Lineages,
+		idx.MergedFrom, idx.MergedTo, idx.Deleted, names} {
+		if err := binary.Write(writer, le, data); err != nil {
+			return err
+		}
+	}
+	for _, name := range idx.Names {
+		if _, err := writer.WriteString(name); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// LoadIndex reads an index from a file.
+func LoadIndex(path string) (*TaxonomyIndex, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReaderSize(file, 1<<20)
+	le := binary.LittleEndian
+
+	magic := make([]byte, len(indexMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != indexMagic {
+		return nil, fmt.Errorf("%s is not a taxonomy index", path)
+	}
+	header := make([]uint32, 6)
+	if err := binary.Read(reader, le, header[:1]); err != nil {
+		return nil, err
+	}
+	if header[0] != IndexVersion {
+		return nil, fmt.Errorf("index %s has version %d but expected %d",
+			path, header[0], IndexVersion)
+	}
+	if err := binary.Read(reader, le, header[1:]); err != nil {
+		return nil, err
+	}
+	n := header[1]
+
+	idx := &TaxonomyIndex{
+		Taxids:     make([]uint32, n),
+		Parents:    make([]uint32, n),
+		Ranks:      make([]uint16, n),
+		Lineages:   make([]uint32, header[2]),
+		RankNames:  make([]string, header[3]),
+		Names:      make([]string, n),
+		MergedFrom: make([]uint32, header[4]),
+		MergedTo:   make([]uint32, header[4]),
+		Deleted:    make([]uint32, header[5]),
+	}
+	if idx.Checksum, err = readString(reader); err != nil {
+		return nil, err
+	}
+	if idx.Format, err = readString(reader); err != nil {
+		return nil, err
+	}
+	for i := range idx.RankNames {
+		if idx.RankNames[i], err = readString(reader); err != nil {
+			return nil, err
+		}
+	}
+	names := make([]uint32, n+1)
+	for _, data := range []any{idx.Taxids, idx.Parents, idx.Ranks, idx.Lineages,
+		idx.MergedFrom, idx.MergedTo, idx.Deleted, names} {
+		if err := binary.Read(reader, le, data); err != nil {
+			return nil, err
+		}
+	}
+	name_data := make([]byte, names[n])
+	if _, err := io.ReadFull(reader, name_data); err != nil {
+		return nil, err
+	}
+	all := string(name_data)
+	for i := range idx.Names {
+		idx.Names[i] = all[names[i]:names[i+1]]
+	}
+	parsed, err := ParseFormat(idx.Format)
+	if err != nil {
+		return nil, err
+	}
+	idx.FormatRanks = parsed.Ranks
+	if len(idx.Lineages) != int(n)*len(idx.FormatRanks) {
+		return nil, errors.New("index lineages do not match the format")
+	}
+
+	return idx, nil
+}
+
+// OpenIndex returns the index for a taxonomy and lineage format. A cached index
+// is used if available, otherwise the index is built and saved to the cache.
+func OpenIndex(taxonomy *FileTaxonomy, format string) (*TaxonomyIndex, error) {
+	key := taxonomy.Backend + "\n" + taxonomy.Path + "\n" + format
+	loadedIndices.Lock()
+	defer loadedIndices.Unlock()
+	if idx, ok := loadedIndices.m[key]; ok {
+		return idx, nil
+	}
+
+	checksum, err := taxonomy.Checksum()
+	if err != nil {
+		return nil, err
+	}
+	path := IndexPath(checksum, format)
+	if path != "" {
+		idx, err := LoadIndex(path)
+		if err == nil && idx.Checksum == checksum && idx.Format == format {
+			log.Printf("Using cached taxonomy index %s.", path)
+			loadedIndices.m[key] = idx
+			return idx, nil
+		}
+	}
+
+	log.Println("Building the taxonomy index...")
+	tree, err := taxonomy.Load()
+	if err != nil {
+		return nil, err
+	}
+	idx := BuildIndex(tree, format)
+	idx.Checksum = checksum
+	if path != "" {
+		err = os.MkdirAll(filepath.Dir(path), 0o755)
+		if err == nil {
+			err = idx.Save(path)
+		}
+		if err != nil {
+			log.Printf("Could not cache the taxonomy index: %v", err)
+		}
+	}
+	loadedIndices.m[key] = idx
+
+	return idx, nil
+}
@@ -0,0 +1,105 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestIndexRoundtrip(t *testing.T) {
+	tree, err := LoadTaxdump(taxdump)
+	if err != nil {
+		t.Fatalf("Could not read the taxonomy dump: %v", err)
+	}
+	format := "{K};{p};{c};{o};{f};{g};{s};{T}"
+	idx := BuildIndex(tree, format)
+	path := filepath.Join(t.TempDir(), "test.idx")
+	if err := idx.Save(path); err != nil {
+		t.Fatalf("Could not save the index: %v", err)
+	}
+	loaded, err := LoadIndex(path)
+	if err != nil {
+		t.Fatalf("Could not load the index: %v", err)
+	}
+
+	ranks := GetRanks(format)
+	taxids := []int{}
+	for taxid := range tree.Taxids {
+		taxids = append(taxids, taxid)
+	}
+	for taxid := range tree.Merged {
+		taxids = append(taxids, taxid)
+	}
+	for taxid := range tree.Deleted {
+		taxids = append(taxids, taxid)
+	}
+	for _, taxid := range taxids {
+		expected := tree.Lineage(strconv.Itoa(taxid), ranks)
+		got := loaded.Lineage(strconv.Itoa(taxid))
+		if !slices.Equal(got.Names, expected.Names) || !slices.Equal(got.Taxids, expected.Taxids) ||
+			got.Taxid != expected.Taxid || got.Merged != expected.Merged ||
+			got.Deleted != expected.Deleted {
+			t.Errorf("Expected lineage %v but got %v.", expected, got)
+		}
+	}
+
+	rebuilt := loaded.Tree()
+	if rebuilt.Root.Taxid != 1 || len(rebuilt.Taxids) != len(tree.Taxids) {
+		t.Error("Tree from the index does not match the original.")
+	}
+	if rebuilt.Taxids[821].Parent.Name != "Phocaeicola" {
+		t.Errorf("Got wrong parent %s for 821.", rebuilt.Taxids[821].Parent.Name)
+	}
+}
+
+func TestOpenIndex(t *testing.T) {
+	t.Setenv("ARCHITEUTHIS_CACHE", t.TempDir())
+	format := "{p};{g};{s}"
+	checksum, err := DetectTaxonomy(taxdump).Checksum()
+	if err != nil {
+		t.Fatalf("Could not calculate the checksum: %v", err)
+	}
+	idx, err := OpenIndex(DetectTaxonomy(taxdump), format)
+	if err != nil {
+		t.Fatalf("Could not open the index: %v", err)
+	}
+	if idx.Checksum != checksum {
+		t.Errorf("Expected checksum %s but got %s.", checksum, idx.Checksum)
+	}
+	if _, err := os.Stat(IndexPath(checksum, format)); err != nil {
+		t.Errorf("Index was not cached: %v", err)
+	}
+	if IndexPath(checksum, format) == IndexPath(checksum, "{g};{s}") {
+		t.Error("Indices for different formats should not share a path.")
+	}
+}
+
+func TestChecksumCache(t *testing.T) {
+	t.Setenv("ARCHITEUTHIS_CACHE", t.TempDir())
+	dir := t.TempDir()
+	for _, name := range []string{"nodes.dmp", "names.dmp"} {
+		data, _ := os.ReadFile(filepath.Join(taxdump, name))
+		os.WriteFile(filepath.Join(dir, name), data, 0o644)
+	}
+	taxonomy := DetectTaxonomy(dir)
+	checksum, err := taxonomy.Checksum()
+	if err != nil {
+		t.Fatalf("Could not calculate the checksum: %v", err)
+	}
+	cached, _ := filepath.Glob(filepath.Join(os.Getenv("ARCHITEUTHIS_CACHE"), "*.sum"))
+	if len(cached) != 1 {
+		t.Fatalf("Expected one cached checksum but got %v.", cached)
+	}
+	if again, _ := taxonomy.Checksum(); again != checksum {
+		t.Errorf("Expected checksum %s but got %s.", checksum, again)
+	}
+
+	names := filepath.Join(dir, "names.dmp")
+	data, _ := os.ReadFile(names)
+	os.WriteFile(names, append(data, "1\t|\tall\t|\t\t|\tsynonym\t|\n"...), 0o644)
+	if changed, _ := taxonomy.Checksum(); changed == checksum {
+		t.Error("Expected a new checksum after the taxonomy changed.")
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const k2taxoMagic = "K2TAXDAT"
+
+// Size of a single taxonomy node in `taxo.k2d` (7 uint64 fields).
+const k2nodeSize = 56
+
+// KrakenTaxonomyFile returns the path of the `taxo.k2d` file in a Kraken2
+// database or an empty string if there is none.
+func KrakenTaxonomyFile(db string) string {
+	path := filepath.Join(db, "taxo.k2d")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// k2string reads a null-terminated string from the Kraken string data.
+func k2string(data []byte, offset uint64) (string, error) {
+	if offset >= uint64(len(data)) {
+		return "", errors.New("string offset out of range in taxo.k2d")
+	}
+	end := bytes.IndexByte(data[offset:], 0)
+	if end < 0 {
+		return string(data[offset:]), nil
+	}
+	return string(data[offset : offset+uint64(end)]), nil
+}
+
+// LoadKrakenTaxonomy reads the binary taxonomy (`taxo.k2d`) of a Kraken2
+// database. The returned tree uses the external (NCBI) taxon IDs.
+func LoadKrakenTaxonomy(path string) (*Tree, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 32 || string(data[:8]) != k2taxoMagic {
+		return nil, fmt.Errorf("%s is not a Kraken2 taxonomy file", path)
+	}
+	le := binary.LittleEndian
+	n_nodes := le.Uint64(data[8:16])
+	name_len := le.Uint64(data[16:24])
+	rank_len := le.Uint64(data[24:32])
+	names_start := 32 + n_nodes*k2nodeSize
+	if uint64(len(data)) < names_start+name_len+rank_len {
+		return nil, fmt.Errorf("taxonomy file %s is truncated", path)
+	}
+	name_data := data[names_start : names_start+name_len]
+	rank_data := data[names_start+name_len : names_start+name_len+rank_len]
+
+	tree := &Tree{Taxids: make(map[int]*Node, n_nodes)}
+	// Internal node 0 is unused by Kraken2 and node 1 is the root.
+	internal := make([]*Node, n_nodes)
+	parents := make([]uint64, n_nodes)
+	for i := uint64(1); i < n_nodes; i++ {
+		rec := data[32+i*k2nodeSize : 32+(i+1)*k2nodeSize]
+		name, err := k2string(name_data, le.Uint64(rec[24:32]))
+		if err != nil {
+			return nil, err
+		}
+		rank, err := k2string(rank_data, le.Uint64(rec[32:40]))
+		if err != nil {
+			return nil, err
+		}
+		node := &Node{Taxid: int(le.Uint64(rec[40:48])), Name: name, Rank: rank}
+		internal[i] = node
+		parents[i] = le.Uint64(rec[0:8])
+		tree.Taxids[node.Taxid] = node
+	}
+
+	for i := uint64(1); i < n_nodes; i++ {
+		node := internal[i]
+		if parents[i] == 0 {
+			tree.Root = node
+			continue
+		}
+		if parents[i] >= n_nodes {
+			return nil, fmt.Errorf("invalid parent for taxon %d in %s", node.Taxid, path)
+		}
+		node.Parent = internal[parents[i]]
+		node.Parent.Children = append(node.Parent.Children, node)
+	}
+
+	return tree, nil
+}
@@ -0,0 +1,139 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// KronaNode is a taxon in a Krona chart with its values in each sample.
+type KronaNode struct {
+	Name  string
+	Taxid string
+	// The values assigned to the taxon itself and to the whole clade.
+	Direct   []float64
+	Total    []float64
+	Children []*KronaNode
+	index    map[string]*KronaNode
+}
+
+func newKronaNode(name string, taxid string, samples int) *KronaNode {
+	return &KronaNode{Name: name, Taxid: taxid, Direct: make([]float64, samples),
+		Total: make([]float64, samples), index: make(map[string]*KronaNode)}
+}
+
+// KronaTree arranges the counts of all samples along the ranks of their
+// lineages. Taxa without lineage are assigned to the root.
+func KronaTree(counts *Counts, lineages map[string]*Lineage, format *LineageFormat) *KronaNode {
+	n := len(counts.Samples)
+	root := newKronaNode("Root", "1", n)
+	for j, s := range counts.Samples {
+		for taxid, value := range counts.Values[s] {
+			node := root
+			node.Total[j] += value
+			if lin := lineages[strconv.Itoa(taxid)]; lin != nil && lin.Taxid != "" {
+				for i, r := range format.Ranks {
+					if !lin.Present[i] {
+						continue
+					}
+					name := strings.TrimPrefix(lin.Names[i], RankPrefixes[r])
+					child, ok := node.index[name]
+					if !ok {
+						child = newKronaNode(name, lin.Taxids[i], n)
+						node.index[name] = child
+						node.Children = append(node.Children, child)
+					}
+					node = child
+					node.Total[j] += value
+				}
+			}
+			node.Direct[j] += value
+		}
+	}
+	root.sort()
+	return root
+}
+
+func (k *KronaNode) sort() {
+	slices.SortFunc(k.Children, func(a, b *KronaNode) int { return strings.Compare(a.Name, b.Name) })
+	for _, c := range k.Children {
+		c.sort()
+	}
+}
+
+// WriteKronaText writes the values of one sample in the format of
+// `ktImportText`. Each line contains a value and the names of its lineage.
+func WriteKronaText(root *KronaNode, sample int, out io.Writer) error {
+	writer := bufio.NewWriter(out)
+	var walk func(node *KronaNode, path []string)
+	walk = func(node *KronaNode, path []string) {
+		if node.Direct[sample] > 0 {
+			writer.WriteString(FormatValue(node.Direct[sample]))
+			for _, name := range path {
+				writer.WriteString("\t" + name)
+			}
+			writer.WriteString("\n")
+		}
+		for _, c := range node.Children {
+			walk(c, append(path, c.Name))
+		}
+	}
+	walk(root, nil)
+	return writer.Flush()
+}
+
+// WriteKronaXML writes the values of all samples as Krona XML with one
+// dataset for each sample. This can be converted to an interactive chart with
+// `ktImportXML`.
+func WriteKronaXML(root *KronaNode, samples []string, out io.Writer) error {
+	writer := bufio.NewWriter(out)
+	writer.WriteString("<krona>\n<attributes magnitude=\"count\">\n")
+	writer.WriteString("<attribute display=\"Count\">count</attribute>\n")
+	writer.WriteString("<attribute display=\"Taxon ID\" mono=\"true\">taxid</attribute>\n")
+	writer.WriteString("</attributes>\n<datasets>\n")
+	for _, s := range samples {
+		writer.WriteString("<dataset>")
+		xml.EscapeText(writer, []byte(s))
+		writer.WriteString("</dataset>\n")
+	}
+	writer.WriteString("</datasets>\n")
+	var walk func(node *KronaNode)
+	walk = func(node *KronaNode) {
+		writer.WriteString("<node name=\"")
+		xml.EscapeText(writer, []byte(node.Name))
+		writer.WriteString("\">\n<count>")
+		for _, v := range node.Total {
+			writer.WriteString("<val>" + FormatValue(v) + "</val>")
+		}
+		writer.WriteString("</count>\n")
+		if node.Taxid != "" {
+			writer.WriteString("<taxid><val>" + node.Taxid + "</val></taxid>\n")
+		}
+		for _, c := range node.Children {
+			walk(c)
+		}
+		writer.WriteString("</node>\n")
+	}
+	walk(root)
+	writer.WriteString("</krona>\n")
+	return writer.Flush()
+}
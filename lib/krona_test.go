@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestKrona(t *testing.T) {
+	format := MustParseFormat("{K};{p};{c};{o};{f};{g};{s}")
+	counts := &Counts{
+		Samples: []string{"A", "B"},
+		Values: map[string]map[int]float64{
+			"A": {562: 10, 817: 5, 816: 1},
+			"B": {817: 2, 12345678: 3},
+		},
+	}
+	lineages := CountLineages(counts, DetectTaxonomy(taxdump), format)
+	root := KronaTree(counts, lineages, format)
+	if root.Total[0] != 16 || root.Total[1] != 5 || root.Direct[1] != 3 {
+		t.Errorf("Unexpected root values %v and %v.", root.Total, root.Direct)
+	}
+
+	var text bytes.Buffer
+	if err := WriteKronaText(root, 1, &text); err != nil {
+		t.Fatal(err)
+	}
+	expected := "3\n2\tBacteria\tBacteroidota\tBacteroidia\tBacteroidales\tBacteroidaceae\tBacteroides\tBacteroides fragilis\n"
+	if text.String() != expected {
+		t.Errorf("Expected Krona text %q but got %q.", expected, text.String())
+	}
+
+	var out bytes.Buffer
+	if err := WriteKronaXML(root, counts.Samples, &out); err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Datasets []string `xml:"datasets>dataset"`
+		Node     struct {
+			Name     string   `xml:"name,attr"`
+			Count    []string `xml:"count>val"`
+			Children []struct {
+				Name  string   `xml:"name,attr"`
+				Count []string `xml:"count>val"`
+			} `xml:"node"`
+		} `xml:"node"`
+	}
+	if err := xml.Unmarshal(out.Bytes(), &parsed); err != nil {
+		t.Fatalf("Could not parse the Krona XML: %v", err)
+	}
+	if strings.Join(parsed.Datasets, ",") != "A,B" || parsed.Node.Name != "Root" {
+		t.Errorf("Unexpected datasets %v or root %s.", parsed.Datasets, parsed.Node.Name)
+	}
+	bacteria := parsed.Node.Children[0]
+	if bacteria.Name != "Bacteria" || strings.Join(bacteria.Count, ",") != "16,2" {
+		t.Errorf("Expected Bacteria with counts 16,2 but got %s with %v.", bacteria.Name, bacteria.Count)
+	}
+}
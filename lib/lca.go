@@ -0,0 +1,290 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"slices"
+)
+
+// Ancestry is implemented by taxonomies that support fast queries on the
+// taxonomy tree. Merged taxon IDs are resolved to their current ID.
+type Ancestry interface {
+	// LCA returns the lowest common ancestor of the taxa. Unknown taxon IDs
+	// are ignored and this is false if none of the taxa is known.
+	LCA(taxids ...int) (int, bool)
+	// Distance returns the number of edges on the path between two taxa.
+	Distance(a int, b int) (int, bool)
+	// IsAncestor checks whether a taxon is an ancestor of another taxon or
+	// the taxon itself.
+	IsAncestor(ancestor int, taxid int) bool
+	// AncestorAt returns the ancestor of a taxon on a given rank such as
+	// "genus".
+	AncestorAt(taxid int, rank string) (int, bool)
+}
+
+// TreeTaxonomy is a taxonomy that supports tree queries.
+type TreeTaxonomy interface {
+	TaxonomyProvider
+	Ancestry
+}
+
+// ancestry answers tree queries for nodes numbered by position. Positions are
+// laid out in a depth-first order that visits the largest subtree first
+// (heavy-light decomposition). So every subtree is a contiguous range, which
+// makes ancestor checks O(1), and every path to the root crosses at most
+// O(log n) heavy paths, which makes LCA queries O(log n) with linear memory.
+type ancestry struct {
+	// Parent positions, the root points to itself.
+	parents []uint32
+	depth   []uint32
+	// The top of the heavy path of each node.
+	head []uint32
+	// The first and last DFS order in the subtree of each node.
+	tin  []uint32
+	tout []uint32
+}
+
+func newAncestry(parents []uint32) *ancestry {
+	n := len(parents)
+	a := &ancestry{
+		parents: parents,
+		depth:   make([]uint32, n),
+		head:    make([]uint32, n),
+		tin:     make([]uint32, n),
+		tout:    make([]uint32, n),
+	}
+
+	start := make([]uint32, n+1)
+	for i, p := range parents {
+		if p != uint32(i) {
+			start[p+1]++
+		}
+	}
+	for i := 0; i < n; i++ {
+		start[i+1] += start[i]
+	}
+	children := make([]uint32, start[n])
+	fill := slices.Clone(start[:n])
+	for i, p := range parents {
+		if p != uint32(i) {
+			children[fill[p]] = uint32(i)
+			fill[p]++
+		}
+	}
+
+	// Visit nodes top-down starting from the roots.
+	order := make([]uint32, 0, n)
+	for i, p := range parents {
+		if p == uint32(i) {
+			order = append(order, uint32(i))
+		}
+	}
+	roots := len(order)
+	for k := 0; k < len(order); k++ {
+		v := order[k]
+		for _, c := range children[start[v]:start[v+1]] {
+			a.depth[c] = a.depth[v] + 1
+			order = append(order, c)
+		}
+	}
+
+	size := make([]uint32, n)
+	for k := len(order) - 1; k >= 0; k-- {
+		v := order[k]
+		size[v]++
+		if parents[v] != v {
+			size[parents[v]] += size[v]
+		}
+	}
+
+	// The DFS orders can be assigned top-down since subtree sizes are known.
+	next := uint32(0)
+	for _, r := range order[:roots] {
+		a.tin[r] = next
+		a.head[r] = r
+		next += size[r]
+	}
+	for _, v := range order {
+		kids := children[start[v]:start[v+1]]
+		pos := a.tin[v] + 1
+		heavy := -1
+		for i, c := range kids {
+			if heavy < 0 || size[c] > size[kids[heavy]] {
+				heavy = i
+			}
+		}
+		if heavy >= 0 {
+			c := kids[heavy]
+			a.tin[c] = pos
+			a.head[c] = a.head[v]
+			pos += size[c]
+		}
+		for i, c := range kids {
+			if i == heavy {
+				continue
+			}
+			a.tin[c] = pos
+			a.head[c] = c
+			pos += size[c]
+		}
+		a.tout[v] = a.tin[v] + size[v] - 1
+	}
+
+	return a
+}
+
+func (a *ancestry) isAncestor(u uint32, v uint32) bool {
+	return a.tin[u] <= a.tin[v] && a.tin[v] <= a.tout[u]
+}
+
+// lca returns the lowest common ancestor of two positions or false if they
+// are in different trees.
+func (a *ancestry) lca(u uint32, v uint32) (uint32, bool) {
+	for a.head[u] != a.head[v] {
+		if a.depth[a.head[u]] < a.depth[a.head[v]] {
+			u, v = v, u
+		}
+		h := a.head[u]
+		if a.parents[h] == h {
+			return 0, false
+		}
+		u = a.parents[h]
+	}
+	if a.depth[u] < a.depth[v] {
+		return u, true
+	}
+	return v, true
+}
+
+// ancestry returns the tree query structure, built on first use.
+func (idx *TaxonomyIndex) ancestry() *ancestry {
+	idx.ancOnce.Do(func() {
+		idx.anc = newAncestry(idx.Parents)
+	})
+	return idx.anc
+}
+
+// LCA returns the lowest common ancestor of the taxa.
+func (idx *TaxonomyIndex) LCA(taxids ...int) (int, bool) {
+	anc := idx.ancestry()
+	found := false
+	var lca uint32
+	for _, taxid := range taxids {
+		pos, _ := idx.Resolve(taxid)
+		if pos < 0 {
+			continue
+		}
+		if !found {
+			lca, found = uint32(pos), true
+			continue
+		}
+		var ok bool
+		if lca, ok = anc.lca(lca, uint32(pos)); !ok {
+			return 0, false
+		}
+	}
+	if !found {
+		return 0, false
+	}
+	return int(idx.Taxids[lca]), true
+}
+
+// Distance returns the number of edges on the path between two taxa.
+func (idx *TaxonomyIndex) Distance(a int, b int) (int, bool) {
+	anc := idx.ancestry()
+	pa, _ := idx.Resolve(a)
+	pb, _ := idx.Resolve(b)
+	if pa < 0 || pb < 0 {
+		return 0, false
+	}
+	lca, ok := anc.lca(uint32(pa), uint32(pb))
+	if !ok {
+		return 0, false
+	}
+	return int(anc.depth[pa] + anc.depth[pb] - 2*anc.depth[lca]), true
+}
+
+// IsAncestor checks whether a taxon is an ancestor of another taxon or the
+// taxon itself.
+func (idx *TaxonomyIndex) IsAncestor(ancestor int, taxid int) bool {
+	pa, _ := idx.Resolve(ancestor)
+	pt, _ := idx.Resolve(taxid)
+	if pa < 0 || pt < 0 {
+		return false
+	}
+	return idx.ancestry().isAncestor(uint32(pa), uint32(pt))
+}
+
+// AncestorAt returns the ancestor of a taxon on a given rank.
+func (idx *TaxonomyIndex) AncestorAt(taxid int, rank string) (int, bool) {
+	pos, _ := idx.Resolve(taxid)
+	rid := slices.Index(idx.RankNames, rank)
+	if pos < 0 || rid < 0 {
+		return 0, false
+	}
+	for {
+		if idx.Ranks[pos] == uint16(rid) {
+			return int(idx.Taxids[pos]), true
+		}
+		if idx.Parents[pos] == uint32(pos) {
+			return 0, false
+		}
+		pos = int(idx.Parents[pos])
+	}
+}
+
+// index returns a taxonomy index of the tree used for tree queries. The tree
+// must not be changed after the first query.
+func (t *Tree) index() *TaxonomyIndex {
+	t.idxOnce.Do(func() {
+		t.idx = BuildIndex(t, "")
+	})
+	return t.idx
+}
+
+// LCA returns the lowest common ancestor of the taxa. Unknown taxon IDs are
+// ignored and this is false if none of the taxa is known.
+func (t *Tree) LCA(taxids ...int) (int, bool) {
+	return t.index().LCA(taxids...)
+}
+
+// Distance returns the number of edges on the path between two taxa.
+func (t *Tree) Distance(a int, b int) (int, bool) {
+	return t.index().Distance(a, b)
+}
+
+// IsAncestor checks whether a taxon is an ancestor of another taxon or the
+// taxon itself.
+func (t *Tree) IsAncestor(ancestor int, taxid int) bool {
+	return t.index().IsAncestor(ancestor, taxid)
+}
+
+// AncestorAt returns the ancestor of a taxon on a given rank.
+func (t *Tree) AncestorAt(taxid int, rank string) (int, bool) {
+	node, ok := t.Resolve(taxid)
+	for ; ok && node != nil; node = node.Parent {
+		if node.Rank == rank {
+			return node.Taxid, true
+		}
+	}
+	return 0, false
+}
+
+// TaxidsByName returns the taxon IDs with the given name ignoring case.
+func (t *Tree) TaxidsByName(name string) []int {
+	return t.index().TaxidsByName(name)
+}
@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"testing"
+)
+
+// naiveLCA finds the lowest common ancestor by walking up the tree.
+func naiveLCA(tree *Tree, a int, b int) int {
+	seen := make(map[int]bool)
+	for node := tree.Taxids[a]; node != nil; node = node.Parent {
+		seen[node.Taxid] = true
+	}
+	for node := tree.Taxids[b]; node != nil; node = node.Parent {
+		if seen[node.Taxid] {
+			return node.Taxid
+		}
+	}
+	return -1
+}
+
+func TestLCA(t *testing.T) {
+	tree, err := LoadTaxdump(taxdump)
+	if err != nil {
+		t.Fatalf("Could not read the taxonomy dump: %v", err)
+	}
+
+	for a := range tree.Taxids {
+		for b := range tree.Taxids {
+			expected := naiveLCA(tree, a, b)
+			if lca, ok := tree.LCA(a, b); !ok || lca != expected {
+				t.Errorf("Expected LCA %d for %d and %d but got %d.", expected, a, b, lca)
+			}
+			anc := expected == a
+			if tree.IsAncestor(a, b) != anc {
+				t.Errorf("Expected IsAncestor(%d, %d) to be %v.", a, b, anc)
+			}
+		}
+	}
+
+	if lca, _ := tree.LCA(820, 821, 46503); lca != 171549 {
+		t.Errorf("Expected LCA %d but got %d.", 171549, lca)
+	}
+	if lca, _ := tree.LCA(1000001, 818); lca != 815 {
+		t.Errorf("Merged taxa should be resolved but got LCA %d.", lca)
+	}
+	if lca, ok := tree.LCA(0, 562); !ok || lca != 562 {
+		t.Errorf("Unknown taxa should be ignored but got LCA %d.", lca)
+	}
+	if _, ok := tree.LCA(0, 1000003); ok {
+		t.Error("Expected no LCA for unknown taxa.")
+	}
+
+	if d, _ := tree.Distance(821, 820); d != 4 {
+		t.Errorf("Expected distance %d but got %d.", 4, d)
+	}
+	if d, _ := tree.Distance(83333, 83333); d != 0 {
+		t.Errorf("Expected distance %d but got %d.", 0, d)
+	}
+	if _, ok := tree.Distance(0, 562); ok {
+		t.Error("Expected no distance for unknown taxa.")
+	}
+
+	if genus, _ := tree.AncestorAt(83333, "genus"); genus != 561 {
+		t.Errorf("Expected genus %d but got %d.", 561, genus)
+	}
+	if _, ok := tree.AncestorAt(547, "species"); ok {
+		t.Error("Expected no species ancestor for a genus.")
+	}
+
+	taxonomy := DetectTaxonomy(taxdump)
+	if lca, _ := taxonomy.LCA(817, 46506); lca != 816 {
+		t.Errorf("Expected LCA %d but got %d.", 816, lca)
+	}
+	if genus, _ := taxonomy.AncestorAt(821, "genus"); genus != 909656 {
+		t.Errorf("Expected genus %d but got %d.", 909656, genus)
+	}
+}
@@ -22,7 +22,6 @@ import (
 	"fmt"
 	"log"
 	"math"
-	"os"
 	"slices"
 	"strconv"
 	"strings"
@@ -32,11 +31,38 @@ type Taxon struct {
 	Lineage string
 	Reads   int
 	Classes map[string]int
+	// The taxonomic rank of each class and the name of the classified taxon
+	// if it is not on a rank of the lineage format.
+	Ranks map[string]string
+	Name  string
+	// The lineage of the classification and the taxon ID of each class.
+	Reference *Lineage
+	Taxids    map[string]string
+}
+
+// inLineage checks whether a class is the classified taxon or one of the real
+// ranks of its lineage. Filled ranks are not part of the lineage.
+func (t *Taxon) inLineage(class string) bool {
+	taxid, ok := t.Taxids[class]
+	if !ok || t.Reference == nil {
+		return false
+	}
+	if taxid == t.Reference.Taxid {
+		return true
+	}
+	for i, ref := range t.Reference.Taxids {
+		if t.Reference.Present[i] && ref == taxid {
+			return true
+		}
+	}
+	return false
 }
 
 type ReadScore struct {
 	TaxonName    string
+	Rank         string
 	TaxonID      uint32
+	RemappedID   uint32
 	ID           string
 	Kmers        uint32
 	Consistency  float64
@@ -47,9 +73,10 @@ type ReadScore struct {
 
 type Mapping map[string]*Taxon
 
-// Summarize combines
-func SummarizeKmers(filepath string, named bool) (Mapping, error) {
-	k2file, err := os.Open(filepath)
+// Summarize combines the k-mer assignments of all reads classified to the same
+// taxon. The taxonomy is only used for Centrifuge output and may be nil.
+func SummarizeKmers(filepath string, named bool, taxonomy TaxonomyProvider) (Mapping, error) {
+	k2file, err := OpenFile(filepath)
 	if err != nil {
 		log.Fatal(err)
 		return nil, err
@@ -58,7 +85,7 @@ func SummarizeKmers(filepath string, named bool) (Mapping, error) {
 
 	reads := 0
 	k2map := make(Mapping)
-	scanner := bufio.NewScanner(k2file)
+	scanner := NewReadScanner(k2file, taxonomy)
 	log.Printf("Reading k-mer assignments from %s.", filepath)
 	for scanner.Scan() {
 		err := ParseMapping(k2map, scanner.Text(), named)
@@ -70,6 +97,9 @@ func SummarizeKmers(filepath string, named bool) (Mapping, error) {
 			log.Printf("Processed %d reads...", reads)
 		}
 	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("The parser encountered an error: %s", err)
+	}
 	if reads%1e6 == 0 {
 		log.Printf("Processed %d reads...", reads)
 	}
@@ -121,7 +151,13 @@ func TaxID(token string, named bool) string {
 	return token
 }
 
-func ScoreRead(line string, taxondb map[string]*Lineage, named bool) *ReadScore {
+// ScoreRead calculates the scores for a single read. K-mers are consistent if
+// their taxon is an ancestor or descendant of the read's taxon. Confidence,
+// entropy and multiplicity compare the k-mers on the rank of the read's taxon,
+// which may also be a strain or subspecies. Taxa without a rank such as "no
+// rank" nodes are compared on the next higher rank. Reads classified to the
+// root or to unknown taxa are not scored.
+func ScoreRead(line string, taxondb map[string]*Lineage, taxonomy TreeTaxonomy, named bool) *ReadScore {
 	tokens := strings.Split(strings.Trim(line, " "), "\t")
 	if tokens[0] != "C" {
 		return nil
@@ -132,17 +168,30 @@ func ScoreRead(line string, taxondb map[string]*Lineage, named bool) *ReadScore
 		log.Fatalf("Uh-oh I thought taxon ID %s was numeric.", taxid)
 	}
 	lin := taxondb[taxid]
-	ridx, leaf := GetLeaf(lin)
-	if ridx == -1 {
+	if lin == nil || lin.Taxid == "" {
 		return nil
 	}
+	read, _ := strconv.Atoi(lin.Taxid)
+	if _, ok := taxonomy.Parent(read); !ok {
+		return nil
+	}
+	rank, _ := taxonomy.Rank(read)
+	level := levelRank(taxonomy, read)
+	// K-mers are grouped by their ancestor on the level of the read, so reads
+	// on taxa without a rank are counted under their closest ranked ancestor.
+	key := lin.Taxid
+	if rank != level && level != "" {
+		if anc, ok := taxonomy.AncestorAt(read, level); ok {
+			key = strconv.Itoa(anc)
+		}
+	}
 
 	// Get classifications
 	abundances := make(map[string]uint32)
 	consistent := 0
 	classified := 0
 	var splits []string
-	for _, s := range strings.Split(tokens[4], " ") {
+	for _, s := range strings.Fields(tokens[4]) {
 		splits = strings.SplitN(s, ":", 2)
 		if splits[0] == "|" || splits[0] == "A" {
 			continue
@@ -152,27 +201,25 @@ func ScoreRead(line string, taxondb map[string]*Lineage, named bool) *ReadScore
 		if err != nil || err2 != nil {
 			log.Fatalf("Could not parse taxon ID %s:%s.", splits[0], splits[1])
 		}
-
-		if tid > 1 {
-			kmer_lin := taxondb[splits[0]]
-			idx, name := GetLeaf(kmer_lin)
-			if idx == -1 {
-				continue
-			}
-			if idx > ridx {
-				name = kmer_lin.Names[ridx]
-			}
-			if idx >= ridx {
-				_, ok := abundances[name]
-				if ok {
-					abundances[name] += uint32(cn)
-				} else {
-					abundances[name] = uint32(cn)
-				}
-			}
-			classified += cn
-			if slices.Contains(lin.Names, name) {
-				consistent += cn
+		if tid <= 1 {
+			continue
+		}
+		kmer_lin := taxondb[splits[0]]
+		if kmer_lin == nil || kmer_lin.Taxid == "" {
+			continue
+		}
+		kmer_tid, _ := strconv.Atoi(kmer_lin.Taxid)
+
+		classified += cn
+		switch {
+		case taxonomy.IsAncestor(read, kmer_tid):
+			consistent += cn
+			abundances[key] += uint32(cn)
+		case taxonomy.IsAncestor(kmer_tid, read):
+			consistent += cn
+		case level != "":
+			if anc, ok := taxonomy.AncestorAt(kmer_tid, level); ok {
+				abundances[strconv.Itoa(anc)] += uint32(cn)
 			}
 		}
 	}
@@ -180,132 +227,290 @@ func ScoreRead(line string, taxondb map[string]*Lineage, named bool) *ReadScore
 	score := ReadScore{
 		ID:           tokens[1],
 		TaxonID:      uint32(taxid_int),
-		TaxonName:    leaf,
+		RemappedID:   uint32(read),
+		TaxonName:    taxonName(taxonomy, lin, read),
+		Rank:         rank,
 		Entropy:      Entropy(abundances),
 		Multiplicity: Multiplicity(abundances),
 		Kmers:        uint32(classified),
 		Consistency:  float64(consistent) / float64(classified),
-		Confidence:   Confidence(abundances, leaf),
+		Confidence:   Confidence(abundances, key),
 	}
 
 	return &score
 }
 
-func ScoreReadsToFile(k2path string, out string, data_dir string, format string, named bool) error {
-	// Set up Kraken reader
-	sample_id := strings.Split(k2path, ".")[0]
-	k2file, err := os.Open(k2path)
+// levelRank returns the rank of a taxon or of its closest ancestor with a rank
+// if the taxon has none.
+func levelRank(taxonomy TaxonomyProvider, taxid int) string {
+	for ok := true; ok; taxid, ok = taxonomy.Parent(taxid) {
+		rank, _ := taxonomy.Rank(taxid)
+		if rank != "" && rank != "no rank" && rank != "clade" {
+			return rank
+		}
+	}
+	return ""
+}
+
+// taxonName returns the lineage name of a taxon if it is on a rank of the
+// lineage format and its scientific name otherwise.
+func taxonName(taxonomy TaxonomyProvider, lin *Lineage, taxid int) string {
+	if i := slices.Index(lin.Taxids, strconv.Itoa(taxid)); i >= 0 {
+		return lin.Names[i]
+	}
+	name, _ := taxonomy.Name(taxid)
+	return name
+}
+
+// treeTaxonomy returns a taxonomy that supports tree queries. For other
+// taxonomies this builds the subtree containing the taxa of the lineages.
+func treeTaxonomy(taxonomy TaxonomyProvider, lineages map[string]*Lineage) TreeTaxonomy {
+	if tree, ok := taxonomy.(TreeTaxonomy); ok {
+		return tree
+	}
+	taxids := make([]int, 0, len(lineages))
+	for _, lin := range lineages {
+		if taxid, err := strconv.Atoi(lin.Taxid); err == nil {
+			taxids = append(taxids, taxid)
+		}
+	}
+	tree, err := Subtree(taxonomy, taxids)
 	if err != nil {
-		log.Fatalf("Could not open %s. Does this file exist?", k2path)
+		log.Fatalf("could not read the taxonomy: %v", err)
 	}
-	defer k2file.Close()
+	return tree
+}
 
-	// Set up output
-	sfile, err := os.Create(out)
+// streamBatch is the number of reads whose lineages are obtained together when
+// scoring reads in a single pass.
+const streamBatch = 10000
+
+// scoreReads scores all reads in a Kraken2, Centrifuge or Kaiju file and calls
+// `emit` with the original record of every scored read. Files are read twice,
+// first to obtain the lineages of all taxa and then to score the reads. Stdin
+// is scored in a single pass if the taxonomy supports tree queries and is
+// buffered in a temporary file otherwise.
+func scoreReads(k2path string, taxonomy TaxonomyProvider, format *LineageFormat,
+	named bool, emit func(line string, score *ReadScore)) (int, error) {
+	if k2path == StdStream {
+		if tree, ok := taxonomy.(TreeTaxonomy); ok {
+			return streamReads(k2path, tree, format, named, emit)
+		}
+		spooled, cleanup, err := Spool(k2path)
+		if err != nil {
+			return 0, err
+		}
+		defer cleanup()
+		k2path = spooled
+	}
+
+	k2file, err := OpenFile(k2path)
 	if err != nil {
-		return err
+		log.Fatalf("Could not open %s. Does this file exist?", k2path)
 	}
-	defer sfile.Close()
-	writer := csv.NewWriter(sfile)
-	header := []string{
-		"sample_id", "read_id", "taxid", "name", "rank", "n_kmers",
-		"consistency", "confidence", "multiplicity", "entropy"}
-	writer.Write(header)
+	defer k2file.Close()
 
 	log.Println("Pass 1: Building the taxa database...")
-	taxondb, _ := TaxonDB(k2path, data_dir, format, named)
+	taxondb, _ := TaxonDB(k2path, taxonomy, format, named)
+	tree := treeTaxonomy(taxonomy, taxondb)
 
 	reads := 0
-	scanner := bufio.NewScanner(k2file)
+	scanner := NewReadScanner(k2file, taxonomy)
 
 	log.Println("Pass 2: Score individuals reads...")
-	log.Printf("Reading k-mer assignments from %s an dwriting to %s.", k2path, out)
 	for scanner.Scan() {
-		s := ScoreRead(scanner.Text(), taxondb, named)
+		s := ScoreRead(scanner.Text(), taxondb, tree, named)
 
 		reads += 1
 		if reads%1e6 == 0 {
 			log.Printf("Processed %d reads...", reads)
 		}
 
-		if s == nil {
-			continue
-		}
-		record := []string{
-			sample_id, s.ID, strconv.Itoa(int(s.TaxonID)), s.TaxonName,
-			strings.Split(s.TaxonName, "__")[0], strconv.Itoa(int(s.Kmers)),
-			fmt.Sprint(s.Consistency), fmt.Sprint(s.Confidence),
-			strconv.Itoa(int(s.Multiplicity)), fmt.Sprint(s.Entropy),
+		if s != nil {
+			emit(scanner.Record(), s)
 		}
-		writer.Write(record)
 	}
 	if err := scanner.Err(); err != nil {
 		log.Fatalf("The parser encountered an error: %s", err)
 	}
 
-	log.Printf("Processing %d reads - Done.", reads)
-	writer.Flush()
-
-	return nil
+	return reads, nil
 }
 
-func FilterReads(k2path string, out string, data_dir string,
-	format string, named bool, min_consistency float64, max_entropy float64, max_multiplicity uint32) error {
-	// Set up Kraken reader
-	k2file, err := os.Open(k2path)
+// streamReads scores reads in a single pass. Lineages are obtained for batches
+// of reads as new taxa appear.
+func streamReads(k2path string, taxonomy TreeTaxonomy, format *LineageFormat,
+	named bool, emit func(line string, score *ReadScore)) (int, error) {
+	k2file, err := OpenFile(k2path)
 	if err != nil {
-		log.Fatalf("Could not open %s. Does this file exist?", k2path)
+		return 0, err
 	}
 	defer k2file.Close()
 
+	taxondb := make(map[string]*Lineage, 1e4)
+	var remapped RemapSummary
+	reads := 0
+	batch := make([]string, 0, streamBatch)
+	records := make([]string, 0, streamBatch)
+	score := func() {
+		missing := make(map[string]bool)
+		for _, line := range batch {
+			readTaxids(line, named, missing)
+		}
+		for tid := range missing {
+			if _, ok := taxondb[tid]; ok {
+				delete(missing, tid)
+			}
+		}
+		if len(missing) > 0 {
+			for tid, lin := range AddLineage(missing, taxonomy, format) {
+				taxondb[tid] = lin
+			}
+		}
+		for i, line := range batch {
+			if tid := readTaxids(line, named, nil); tid != "" {
+				remapped.Count(taxondb[tid], 1)
+			}
+			if s := ScoreRead(line, taxondb, taxonomy, named); s != nil {
+				emit(records[i], s)
+			}
+			reads += 1
+			if reads%1e6 == 0 {
+				log.Printf("Processed %d reads...", reads)
+			}
+		}
+		batch = batch[:0]
+		records = records[:0]
+	}
+
+	log.Println("Scoring reads in a single pass...")
+	scanner := NewReadScanner(k2file, taxonomy)
+	for scanner.Scan() {
+		batch = append(batch, scanner.Text())
+		records = append(records, scanner.Record())
+		if len(batch) == streamBatch {
+			score()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("The parser encountered an error: %s", err)
+	}
+	score()
+
+	log.Printf("Obtained lineage information for %d unique taxa.", len(taxondb))
+	remapped.Log("reads")
+
+	return reads, nil
+}
+
+func ScoreReadsToFile(k2path string, out string, taxonomy TaxonomyProvider, format *LineageFormat, named bool) (err error) {
+	sample_id := SampleID(k2path)
+	metadata := SampleMetadata(sample_id)
+
 	// Set up output
-	sfile, err := os.Create(out)
+	sfile, err := CreateFile(out)
 	if err != nil {
 		return err
 	}
-	defer sfile.Close()
-	writer := bufio.NewWriter(sfile)
-
-	log.Println("Pass 1: Building the taxa database...")
-	taxondb, _ := TaxonDB(k2path, data_dir, format, named)
+	defer func() {
+		if cerr := sfile.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	writer := csv.NewWriter(sfile)
+	header := []string{
+		"sample_id", "read_id", "taxid", "remapped_taxid", "name", "rank", "n_kmers",
+		"consistency", "confidence", "multiplicity", "entropy"}
+	writer.Write(append(header, SampleColumns()...))
 
-	reads := 0
-	passed := 0
+	log.Printf("Reading k-mer assignments from %s and writing to %s.", k2path, out)
+	reads, err := scoreReads(k2path, taxonomy, format, named, func(line string, s *ReadScore) {
+		record := []string{
+			sample_id, s.ID, strconv.Itoa(int(s.TaxonID)),
+			strconv.Itoa(int(s.RemappedID)), s.TaxonName,
+			s.Rank, strconv.Itoa(int(s.Kmers)),
+			fmt.Sprint(s.Consistency), fmt.Sprint(s.Confidence),
+			strconv.Itoa(int(s.Multiplicity)), fmt.Sprint(s.Entropy),
+		}
+		writer.Write(append(record, metadata...))
+	})
+	if err != nil {
+		return err
+	}
 
-	scanner := bufio.NewScanner(k2file)
+	log.Printf("Processing %d reads - Done.", reads)
+	writer.Flush()
 
-	log.Println("Pass 2: Score individuals reads...")
-	log.Printf("Reading k-mer assignments from %s and writing to %s.", k2path, out)
-	for scanner.Scan() {
-		s := ScoreRead(scanner.Text(), taxondb, named)
+	return writer.Error()
+}
 
-		reads += 1
-		if reads%1e6 == 0 {
-			log.Printf("Processed %d reads...", reads)
+func FilterReads(k2path string, out string, taxonomy TaxonomyProvider,
+	format *LineageFormat, named bool, min_consistency float64, max_entropy float64, max_multiplicity uint32) (err error) {
+	// Set up output
+	sfile, err := CreateFile(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := sfile.Close(); err == nil {
+			err = cerr
 		}
+	}()
+	writer := bufio.NewWriter(sfile)
+	if filetype, _ := GetFormat(k2path); filetype == "centrifuge" {
+		writer.WriteString(strings.Join(centrifuge_header, "\t") + "\n")
+	}
 
-		if s == nil || s.Consistency < min_consistency ||
+	passed := 0
+	log.Printf("Reading k-mer assignments from %s and writing to %s.", k2path, out)
+	reads, err := scoreReads(k2path, taxonomy, format, named, func(line string, s *ReadScore) {
+		if s.Consistency < min_consistency ||
 			s.Entropy > max_entropy || s.Multiplicity > max_multiplicity {
-			continue
+			return
 		}
-		writer.Write(scanner.Bytes())
+		writer.WriteString(line)
 		writer.WriteRune('\n')
 
 		passed += 1
-	}
-	if err := scanner.Err(); err != nil {
-		log.Fatalf("The parser encountered an error: %s", err)
+	})
+	if err != nil {
+		return err
 	}
 
 	log.Printf("Processed %d reads - Done. %d/%d reads passed the filter.",
 		reads, passed, reads)
-	writer.Flush()
 
-	return nil
+	return writer.Flush()
 }
 
-func TaxonDB(filepath string, data_dir string, format string, named bool) (map[string]*Lineage, int) {
-	k2file, err := os.Open(filepath)
+// readTaxids adds the taxon IDs of the classification and k-mers of a read to
+// `taxids` and returns the classification. This is empty for unclassified
+// reads.
+func readTaxids(line string, named bool, taxids map[string]bool) string {
+	tokens := strings.Split(strings.Trim(line, " "), "\t")
+	if tokens[0] != "C" {
+		return ""
+	}
+	tid := TaxID(tokens[2], named)
+	if taxids == nil {
+		return tid
+	}
+	taxids[tid] = true
+
+	for _, s := range strings.Fields(tokens[4]) {
+		splits := strings.Split(s, ":")
+		if splits[0] == "|" || splits[0] == "A" {
+			continue
+		}
+		if splits[0] != "0" && splits[0] != "1" {
+			taxids[splits[0]] = true
+		}
+	}
+	return tid
+}
+
+func TaxonDB(filepath string, taxonomy TaxonomyProvider, format *LineageFormat, named bool) (map[string]*Lineage, int) {
+	k2file, err := OpenFile(filepath)
 	if err != nil {
 		log.Fatalf("Could not open %s. Does this file exist?", filepath)
 	}
@@ -313,29 +518,18 @@ func TaxonDB(filepath string, data_dir string, format string, named bool) (map[s
 
 	reads := 0
 	classified := 0
-	scanner := bufio.NewScanner(k2file)
+	scanner := NewReadScanner(k2file, taxonomy)
 	taxids := make(map[string]bool, 1e4)
+	assigned := make(map[string]int, 1e3)
 
 	log.Printf("Reading k-mer assignments from %s.", filepath)
 	for scanner.Scan() {
 		reads += 1
-		tokens := strings.Split(strings.Trim(scanner.Text(), " "), "\t")
-		if tokens[0] != "C" {
+		tid := readTaxids(scanner.Text(), named, taxids)
+		if tid == "" {
 			continue
 		}
-		tid := TaxID(tokens[2], named)
-		taxids[tid] = true
-
-		for _, s := range strings.Split(tokens[4], " ") {
-			splits := strings.Split(s, ":")
-			if splits[0] == "|" || splits[0] == "A" {
-				continue
-			}
-			tid := splits[0]
-			if tid != "0" && tid != "1" {
-				taxids[tid] = true
-			}
-		}
+		assigned[tid] += 1
 		classified += 1
 		if reads%1e6 == 0 {
 			log.Printf("Processed %d reads...", reads)
@@ -347,9 +541,14 @@ func TaxonDB(filepath string, data_dir string, format string, named bool) (map[s
 
 	log.Printf("Processed %d reads - Done.", reads)
 
-	lineages := AddLineage(taxids, data_dir, format)
+	lineages := AddLineage(taxids, taxonomy, format)
 	log.Printf("%d reads had assigned taxa. Obtained lineage information for %d unique taxa.",
 		classified, len(lineages))
+	var remapped RemapSummary
+	for tid, n := range assigned {
+		remapped.Count(lineages[tid], n)
+	}
+	remapped.Log("reads")
 
 	return lineages, reads
 }
@@ -365,7 +564,7 @@ func ParseMapping(k2map Mapping, line string, named bool) error {
 	}
 	entry.Reads += 1
 
-	for _, s := range strings.Split(tokens[4], " ") {
+	for _, s := range strings.Fields(tokens[4]) {
 		splits := strings.SplitN(s, ":", 2)
 		if splits[0] == "|" || splits[0] == "A" {
 			continue
@@ -392,37 +591,45 @@ func UpdateMapping(entry *Taxon, kmer_taxid string, count int) {
 	}
 }
 
-func SaveMapping(k2map Mapping, filepath string, sample_id string) error {
+func SaveMapping(k2map Mapping, filepath string, sample_id string) (err error) {
 	var has_lineage bool
 	for k := range k2map {
 		has_lineage = (k2map[k].Lineage != "")
 		break
 	}
-	mfile, err := os.Create(filepath)
+	mfile, err := CreateFile(filepath)
 	if err != nil {
 		log.Fatal("Could not open file for writing!")
 		return err
 	}
-	defer mfile.Close()
+	defer func() {
+		if cerr := mfile.Close(); err == nil {
+			err = cerr
+		}
+	}()
 	writer := csv.NewWriter(mfile)
+	metadata := SampleMetadata(sample_id)
 	if has_lineage {
-		writer.Write([]string{
+		writer.Write(append([]string{
 			"sample_id", "classification", "lineage", "total_reads",
-			"name", "rank", "kmers", "in_lineage"})
+			"name", "rank", "kmers", "in_lineage"}, SampleColumns()...))
 	} else {
-		writer.Write([]string{
+		writer.Write(append([]string{
 			"sample_id", "classification", "total_reads",
-			"taxid", "kmers"})
+			"taxid", "kmers"}, SampleColumns()...))
 	}
 	var recs []string
 	for class, v := range k2map {
 		for taxid, n := range v.Classes {
 			if has_lineage {
 				match := 0
-				if strings.Contains(v.Lineage, taxid) {
+				if v.inLineage(taxid) {
 					match = 1
 				}
-				rank := strings.Split(taxid, "__")[0]
+				rank, ok := v.Ranks[taxid]
+				if !ok {
+					rank = strings.Split(taxid, "__")[0]
+				}
 				recs = []string{
 					sample_id, class, v.Lineage, strconv.Itoa(v.Reads),
 					taxid, rank, strconv.Itoa(n), strconv.Itoa(match)}
@@ -431,7 +638,7 @@ func SaveMapping(k2map Mapping, filepath string, sample_id string) error {
 					sample_id, class, strconv.Itoa(v.Reads),
 					taxid, strconv.Itoa(n)}
 			}
-			writer.Write(recs)
+			writer.Write(append(recs, metadata...))
 		}
 	}
 	writer.Flush()
@@ -439,7 +646,7 @@ func SaveMapping(k2map Mapping, filepath string, sample_id string) error {
 	return nil
 }
 
-func CollapseRanks(k2map Mapping, data_dir string, format string) Mapping {
+func CollapseRanks(k2map Mapping, taxonomy TaxonomyProvider, format *LineageFormat) Mapping {
 	taxa := make(map[string]bool, 100)
 	for taxid, entry := range k2map {
 		taxa[taxid] = true
@@ -447,21 +654,53 @@ func CollapseRanks(k2map Mapping, data_dir string, format string) Mapping {
 			taxa[k] = true
 		}
 	}
-	lineage := AddLineage(taxa, data_dir, format)
+	lineage := AddLineage(taxa, taxonomy, format)
 	log.Printf("Got taxonomy for %d unique taxa. Collapsing on ranks.", len(k2map))
+	tree := treeTaxonomy(taxonomy, lineage)
+	rank_cache := make(map[string]string)
+	rankOf := func(taxid string) string {
+		rank, ok := rank_cache[taxid]
+		if !ok {
+			tid, _ := strconv.Atoi(taxid)
+			rank, _ = tree.Rank(tid)
+			rank_cache[taxid] = rank
+		}
+		return rank
+	}
 
 	collapsed := make(Mapping, 100)
 	ntaxa := 0
+	var remapped RemapSummary
 
 	for taxid, entry := range k2map {
+		ref_lineage := lineage[taxid]
+		remapped.Count(ref_lineage, entry.Reads)
 		ranks := &Taxon{
-			Lineage: strings.Join(lineage[taxid].Names, ";"),
-			Reads:   entry.Reads,
-			Classes: make(map[string]int, 6)}
+			Lineage:   format.Join(ref_lineage.Names),
+			Reads:     entry.Reads,
+			Classes:   make(map[string]int, 6),
+			Ranks:     make(map[string]string, 6),
+			Reference: ref_lineage,
+			Taxids:    make(map[string]string, 6)}
 		for cl, cn := range entry.Classes {
-			ref_lineage := lineage[taxid]
 			kmer_lineage := lineage[cl]
-			matchRanks(ref_lineage, kmer_lineage, cn, ranks)
+			matchRanks(ref_lineage, kmer_lineage, cn, ranks, rankOf)
+		}
+
+		// Classifications to strains or "no rank" taxa that are not on the
+		// ranks of the format get their own class.
+		read, err := strconv.Atoi(ref_lineage.Taxid)
+		_, has_parent := tree.Parent(read)
+		if err == nil && has_parent && !slices.Contains(ref_lineage.Taxids, ref_lineage.Taxid) {
+			ranks.Name, _ = tree.Name(read)
+			ranks.Ranks[ranks.Name] = rankOf(ref_lineage.Taxid)
+			ranks.Taxids[ranks.Name] = ref_lineage.Taxid
+			for cl, cn := range entry.Classes {
+				kmer_tid, err := strconv.Atoi(lineage[cl].Taxid)
+				if err == nil && tree.IsAncestor(read, kmer_tid) {
+					UpdateMapping(ranks, ranks.Name, cn)
+				}
+			}
 		}
 		collapsed[taxid] = ranks
 		ntaxa += 1
@@ -470,19 +709,22 @@ func CollapseRanks(k2map Mapping, data_dir string, format string) Mapping {
 			log.Printf("Processed %d taxa...", ntaxa)
 		}
 	}
+	remapped.Log("reads")
 
 	return collapsed
 }
 
-func matchRanks(ref_lineage *Lineage, kmer_lineage *Lineage, count int, entry *Taxon) int {
+func matchRanks(ref_lineage *Lineage, kmer_lineage *Lineage, count int, entry *Taxon,
+	rankOf func(string) string) int {
 	matched_ranks := 0
 	for i, kn := range kmer_lineage.Names {
-		rn := ref_lineage.Names[i]
-		if len(kn) < 4 || len(rn) < 4 {
+		if !kmer_lineage.Present[i] || !ref_lineage.Present[i] {
 			break
 		}
 		matched_ranks += 1
 		UpdateMapping(entry, kn, count)
+		entry.Ranks[kn] = rankOf(kmer_lineage.Taxids[i])
+		entry.Taxids[kn] = kmer_lineage.Taxids[i]
 	}
 
 	return matched_ranks
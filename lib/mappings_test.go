@@ -3,6 +3,7 @@ package lib
 import (
 	"bufio"
 	"bytes"
+	"encoding/csv"
 	"log"
 	"os"
 	"path/filepath"
@@ -14,7 +15,7 @@ var lines []string
 
 func init() {
 	filename := filepath.Join("..", "testdata", "test.k2")
-	taxondb, _ = TaxonDB(filename, "", "{K};{p};{c};{o};{f};{g};{s}", false)
+	taxondb, _ = TaxonDB(filename, DetectTaxonomy(""), MustParseFormat("{K};{p};{c};{o};{f};{g};{s}"), false)
 
 	lines = make([]string, 100)
 	k2file, _ := os.Open(filename)
@@ -28,7 +29,7 @@ func init() {
 
 func TestKmers(t *testing.T) {
 	filename := filepath.Join("..", "testdata", "test.k2")
-	k2map, err := SummarizeKmers(filename, false)
+	k2map, err := SummarizeKmers(filename, false, nil)
 	if err != nil {
 		t.Fatal("Error when running summary.")
 	}
@@ -40,11 +41,11 @@ func TestKmers(t *testing.T) {
 
 func TestCollapse(t *testing.T) {
 	filename := filepath.Join("..", "testdata", "test.k2")
-	k2map, err := SummarizeKmers(filename, false)
+	k2map, err := SummarizeKmers(filename, false, nil)
 	if err != nil {
 		t.Fatal("Error when running summary.")
 	}
-	collapsed := CollapseRanks(k2map, "", "{K};{p};{c};{o};{f};{g};{s}")
+	collapsed := CollapseRanks(k2map, DetectTaxonomy(""), MustParseFormat("{K};{p};{c};{o};{f};{g};{s}"))
 
 	c := collapsed["816"]
 	if c.Reads != 93 {
@@ -64,7 +65,7 @@ func TestScoring(t *testing.T) {
 		t.Error("Not initialized.")
 	}
 	for i := 0; i < 10; i++ {
-		score := ScoreRead(lines[i], taxondb, false)
+		score := ScoreRead(lines[i], taxondb, DetectTaxonomy(""), false)
 		if score.Consistency > 1 || score.Consistency < 0 {
 			t.Errorf("Got invalid consistency score: %f", score.Consistency)
 		}
@@ -79,9 +80,93 @@ func TestScoring(t *testing.T) {
 	}
 }
 
+func TestScoreStrain(t *testing.T) {
+	taxonomy := DetectTaxonomy(taxdump)
+	taxids := map[string]bool{"83333": true, "562": true, "561": true, "816": true, "131567": true}
+	db := AddLineage(taxids, taxonomy, MustParseFormat("{K};{p};{c};{o};{f};{g};{s}"))
+
+	score := ScoreRead("C\tr1\t83333\t100\t83333:10 562:5 561:2 816:3 |:| 0:4", db, taxonomy, false)
+	if score == nil {
+		t.Fatal("Reads classified to strains should be scored.")
+	}
+	if score.Rank != "strain" || score.TaxonName != "Escherichia coli K-12" {
+		t.Errorf("Expected the strain but got %s (%s).", score.TaxonName, score.Rank)
+	}
+	if score.Kmers != 20 || score.Consistency != 0.85 {
+		t.Errorf("Expected %d k-mers and consistency %g but got %d and %g.",
+			20, 0.85, score.Kmers, score.Consistency)
+	}
+	if score.Confidence != 1 || score.Multiplicity != 1 {
+		t.Errorf("Expected confidence 1 and multiplicity 1 but got %g and %d.",
+			score.Confidence, score.Multiplicity)
+	}
+
+	score = ScoreRead("C\tr2\t562\t100\t83333:10 562:5", db, taxonomy, false)
+	if score.Rank != "species" || score.TaxonName != "s__Escherichia coli" {
+		t.Errorf("Expected the species but got %s (%s).", score.TaxonName, score.Rank)
+	}
+
+	score = ScoreRead("C\tr3\t131567\t100\t562:5 816:5", db, taxonomy, false)
+	if score == nil || score.Rank != "no rank" || score.Consistency != 1 {
+		t.Errorf("Reads on taxa without a rank should be scored but got %v.", score)
+	}
+}
+
+func TestScoreNoRank(t *testing.T) {
+	tree, err := LoadTaxdump(taxdump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genus, species := tree.Taxids[816], tree.Taxids[817]
+	group := &Node{Taxid: 1100001, Name: "Bacteroides fragilis group", Rank: "no rank", Parent: genus}
+	genus.Children = append(genus.Children, group)
+	group.Children = []*Node{species}
+	species.Parent = group
+	tree.Taxids[group.Taxid] = group
+
+	taxids := map[string]bool{"1100001": true, "817": true, "818": true, "816": true}
+	db := AddLineage(taxids, tree, MustParseFormat("{K};{p};{c};{o};{f};{g};{s}"))
+	score := ScoreRead("C\tr1\t1100001\t100\t817:10 818:5 816:2", db, tree, false)
+	if score == nil {
+		t.Fatal("Reads on taxa without a rank should be scored.")
+	}
+	if score.Multiplicity != 1 || score.Confidence != 1 {
+		t.Errorf("Expected multiplicity 1 and confidence 1 but got %d and %g.",
+			score.Multiplicity, score.Confidence)
+	}
+	if score.Consistency != 12.0/17.0 {
+		t.Errorf("Expected consistency %g but got %g.", 12.0/17.0, score.Consistency)
+	}
+}
+
+func TestInLineage(t *testing.T) {
+	lin := &Lineage{Names: []string{"f__Bacteroidaceae", "g__unclassified Bacteroidaceae", "s__B. sp. 1234"},
+		Taxids: []string{"815", "", "1234"}, Present: []bool{true, false, true},
+		Filled: []bool{false, true, false}, Taxid: "1234"}
+	entry := &Taxon{Lineage: "f__Bacteroidaceae;g__unclassified Bacteroidaceae;s__B. sp. 1234",
+		Reads: 1, Reference: lin,
+		Classes: map[string]int{"f__Bacteroidaceae": 5, "s__B. sp. 12": 3, "g__unclassified Bacteroidaceae": 2},
+		Ranks:   map[string]string{"f__Bacteroidaceae": "family", "s__B. sp. 12": "species"},
+		Taxids:  map[string]string{"f__Bacteroidaceae": "815", "s__B. sp. 12": "12"}}
+	out := filepath.Join(t.TempDir(), "summary.csv")
+	if err := SaveMapping(Mapping{"1234": entry}, out, "S1"); err != nil {
+		t.Fatal(err)
+	}
+	file, _ := os.Open(out)
+	defer file.Close()
+	records, _ := csv.NewReader(file).ReadAll()
+	expected := map[string]string{"f__Bacteroidaceae": "1", "s__B. sp. 12": "0",
+		"g__unclassified Bacteroidaceae": "0"}
+	for _, r := range records[1:] {
+		if r[7] != expected[r[4]] {
+			t.Errorf("Expected in_lineage %s for %s but got %s.", expected[r[4]], r[4], r[7])
+		}
+	}
+}
+
 func BenchmarkScoring(b *testing.B) {
 	for n := 0; n < b.N; n++ {
-		ScoreRead(lines[n%100], taxondb, false)
+		ScoreRead(lines[n%100], taxondb, DetectTaxonomy(""), false)
 	}
 }
 
@@ -91,7 +176,7 @@ func BenchmarkKmers(b *testing.B) {
 
 	filename := filepath.Join("..", "testdata", "test.k2")
 	for n := 0; n < b.N; n++ {
-		SummarizeKmers(filename, false)
+		SummarizeKmers(filename, false, nil)
 	}
 }
 
@@ -100,8 +185,57 @@ func BenchmarkCollapse(b *testing.B) {
 	log.SetOutput(&str)
 
 	filename := filepath.Join("..", "testdata", "test.k2")
-	k2map, _ := SummarizeKmers(filename, false)
+	k2map, _ := SummarizeKmers(filename, false, nil)
 	for n := 0; n < b.N; n++ {
-		CollapseRanks(k2map, "", "{k};{p};{c};{o};{f};{g};{s}")
+		CollapseRanks(k2map, DetectTaxonomy(""), MustParseFormat("{k};{p};{c};{o};{f};{g};{s}"))
+	}
+}
+
+// listTaxonomy hides the tree queries of a taxonomy.
+type listTaxonomy struct {
+	TaxonomyProvider
+}
+
+func TestStreaming(t *testing.T) {
+	dir := t.TempDir()
+	format := MustParseFormat("{K};{p};{c};{o};{f};{g};{s}")
+	taxonomy := DetectTaxonomy(taxdump)
+
+	expected := filepath.Join(dir, "expected.k2")
+	err := FilterReads(simple, expected, taxonomy, format, false, 0.9, 0.1, 2)
+	if err != nil {
+		t.Fatalf("Could not filter reads: %v", err)
+	}
+	want, _ := os.ReadFile(expected)
+
+	var got []byte
+	_, err = streamReads(simple, taxonomy, format, false, func(line string, s *ReadScore) {
+		if s.Consistency < 0.9 || s.Entropy > 0.1 || s.Multiplicity > 2 {
+			return
+		}
+		got = append(got, line+"\n"...)
+	})
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("Expected the same reads when scoring in a single pass but got %d bytes instead of %d.",
+			len(got), len(want))
+	}
+
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	os.Stdin, err = os.Open(simple)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format, _ := GetFormat(StdStream); format != "kraken2" {
+		t.Errorf("Expected format kraken2 for stdin but got %s.", format)
+	}
+	out := filepath.Join(dir, "stdin.k2")
+	err = FilterReads(StdStream, out, listTaxonomy{taxonomy}, format, false, 0.9, 0.1, 2)
+	if err != nil {
+		t.Fatalf("Could not filter reads from stdin: %v", err)
+	}
+	if got, _ := os.ReadFile(out); !bytes.Equal(got, want) {
+		t.Errorf("Expected the same reads from stdin but got %d bytes instead of %d.",
+			len(got), len(want))
 	}
 }
@@ -22,21 +22,26 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"os"
+	"math"
 	"path/filepath"
-	"strings"
+	"slices"
+	"strconv"
 )
 
-func SimpleAppend(files []string, out string, header bool) error {
-	merged, err := os.Create(out)
+func SimpleAppend(files []string, out string, header bool) (err error) {
+	merged, err := CreateFile(out)
 	if err != nil {
 		return err
 	}
-	defer merged.Close()
+	defer func() {
+		if cerr := merged.Close(); err == nil {
+			err = cerr
+		}
+	}()
 	writer := bufio.NewWriter(merged)
 
 	for i, file := range files {
-		fi, err := os.Open(file)
+		fi, err := OpenFile(file)
 		if err != nil {
 			return err
 		}
@@ -66,68 +71,538 @@ func SimpleAppend(files []string, out string, header bool) error {
 	return nil
 }
 
-func SampleAppend(files []string, out string, sep rune) error {
-	merged, err := os.Create(out)
+// ReportColumns are the values of Kraken2 reports that can be used in wide
+// tables. The unique k-mers are only available for KrakenUniq reports.
+var ReportColumns = []string{"clade_reads", "direct_reads", "percentage", "kmers"}
+
+// reportValue returns a value of a taxon in a report. The taxon ID 0 are the
+// unclassified reads.
+func reportValue(report *Report, taxid int, column string) float64 {
+	count := report.Clade(taxid)
+	if taxid == 0 {
+		count = report.Unclassified
+	}
+	switch {
+	case column == "direct_reads" && taxid != 0:
+		return report.Direct[taxid]
+	case column == "kmers":
+		return report.Kmers[taxid]
+	case column == "percentage":
+		if total := report.Total(); total > 0 {
+			return 100 * count / total
+		}
+		return 0
+	}
+	return count
+}
+
+// FormatValue formats a number without exponent. NaN becomes "NA".
+func FormatValue(value float64) string {
+	if math.IsNaN(value) {
+		return "NA"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// MergeReports merges Kraken2 or KrakenUniq reports into a long table with one
+// row for each sample and taxon, including the unclassified reads as taxon ID
+// 0. KrakenUniq reports also contain the unique k-mers, duplication and
+// coverage of each taxon.
+func MergeReports(files []string, out string) (err error) {
+	merged, err := CreateFile(out)
 	if err != nil {
 		return err
 	}
-	defer merged.Close()
+	defer func() {
+		if cerr := merged.Close(); err == nil {
+			err = cerr
+		}
+	}()
 	writer := csv.NewWriter(merged)
+	samples, err := SampleIDs(files)
+	if err != nil {
+		return err
+	}
+
+	// KrakenUniq columns are added if any report has them and left blank for
+	// the other reports.
+	reports := make([]*Report, len(files))
+	kmers := false
+	for i, file := range files {
+		if reports[i], err = ReadReport(file); err != nil {
+			return err
+		}
+		kmers = kmers || reports[i].Kmers != nil
+	}
+	header := []string{"sample_id", "taxid", "name", "rank", "rank_code",
+		"clade_reads", "direct_reads", "percentage"}
+	if kmers {
+		header = append(header, "kmers", "dup", "cov")
+	}
+	header = append(header, SampleColumns()...)
+	if err = writer.Write(header); err != nil {
+		return err
+	}
 
-	var records []string
-	field := make([]string, 1)
-	var n_elems int
 	for i, file := range files {
-		sample_id := strings.Split(filepath.Base(file), ".")[0]
-		fi, err := os.Open(file)
+		sample_id := samples[i]
+		report := reports[i]
+		if kmers && report.Kmers == nil {
+			log.Printf("%s is not a KrakenUniq report, its k-mer columns are left blank.", file)
+		}
+
+		taxa := report.Taxa()
+		if report.Unclassified > 0 {
+			taxa = append([]int{0}, taxa...)
+		}
+		for _, taxid := range taxa {
+			name, rank, code := "unclassified", "", "U"
+			if node, ok := report.Tree.Taxids[taxid]; ok {
+				name, rank, code = node.Name, node.Rank, report.Codes[taxid]
+			}
+			record := []string{
+				sample_id, strconv.Itoa(taxid), name, rank, code,
+				FormatValue(reportValue(report, taxid, "clade_reads")),
+				FormatValue(reportValue(report, taxid, "direct_reads")),
+				FormatValue(reportValue(report, taxid, "percentage")),
+			}
+			if kmers && report.Kmers == nil {
+				record = append(record, "", "", "")
+			} else if kmers {
+				cov, ok := report.Coverage[taxid]
+				if !ok {
+					cov = math.NaN()
+				}
+				record = append(record, FormatValue(report.Kmers[taxid]),
+					FormatValue(report.Duplication[taxid]), FormatValue(cov))
+			}
+			writer.Write(append(record, SampleMetadata(sample_id)...))
+		}
+		log.Printf("Wrote %d records from %s.", len(taxa), file)
+	}
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// MergeReportsWide merges Kraken2 reports into a matrix with one row for each
+// taxon and one column for each sample. Taxa missing from a sample are filled
+// with zeros. Taxa are ordered like in a Kraken2 report of all samples.
+func MergeReportsWide(files []string, out string, column string) (err error) {
+	if !slices.Contains(ReportColumns, column) {
+		return fmt.Errorf("unknown report column `%s`, must be one of %v", column, ReportColumns)
+	}
+
+	samples, err := SampleIDs(files)
+	if err != nil {
+		return err
+	}
+	values := make([]map[int]float64, len(files))
+	combined := &Tree{Taxids: make(map[int]*Node)}
+	unclassified := false
+	root_file := ""
+	for i, file := range files {
+		report, err := ReadReport(file)
 		if err != nil {
 			return err
 		}
-		reader := csv.NewReader(fi)
-		reader.Comma = sep
-		header, err := reader.Read()
+		values[i] = make(map[int]float64, len(report.Tree.Taxids)+1)
+		if report.Unclassified > 0 {
+			unclassified = true
+			values[i][0] = reportValue(report, 0, column)
+		}
+		for _, taxid := range report.Taxa() {
+			node := report.Tree.Taxids[taxid]
+			values[i][taxid] = reportValue(report, taxid, column)
+			entry, ok := combined.Taxids[taxid]
+			if !ok {
+				entry = &Node{Taxid: taxid, Name: node.Name, Rank: node.Rank}
+				combined.Taxids[taxid] = entry
+				if node.Parent == nil {
+					if combined.Root != nil {
+						return fmt.Errorf("the root %d of %s differs from the root %d of %s",
+							taxid, file, combined.Root.Taxid, root_file)
+					}
+					combined.Root, root_file = entry, file
+				} else {
+					entry.Parent = combined.Taxids[node.Parent.Taxid]
+					entry.Parent.Children = append(entry.Parent.Children, entry)
+				}
+			}
+			entry.Value += node.Value
+		}
+		log.Printf("Read %d taxa from %s.", len(report.Tree.Taxids), file)
+	}
+
+	merged, err := CreateFile(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := merged.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	writer := csv.NewWriter(merged)
+	err = writer.Write(append([]string{"taxid", "name", "rank"}, samples...))
+	if err != nil {
+		return err
+	}
+
+	record := make([]string, 3+len(samples))
+	write := func(taxid int, name string, rank string) {
+		record[0], record[1], record[2] = strconv.Itoa(taxid), name, rank
+		for i := range samples {
+			record[3+i] = FormatValue(values[i][taxid])
+		}
+		writer.Write(record)
+	}
+	if unclassified {
+		write(0, "unclassified", "")
+	}
+	combined.Walk(func(node *Node, depth int) {
+		write(node.Taxid, node.Name, node.Rank)
+	})
+	log.Printf("Wrote %d taxa for %d samples.", len(combined.Taxids), len(samples))
+	writer.Flush()
+
+	return writer.Error()
+}
+
+type matrixEntry struct {
+	sample int
+	value  float64
+}
+
+// BrackenMatrix holds the values of one column of Bracken files for each
+// taxon and sample. Only non-zero values are stored.
+type BrackenMatrix struct {
+	Samples []string
+	// Taxa in the order of their first appearance.
+	Taxa  []int
+	Names map[int]string
+	Ranks map[int]string
+	// Lineages from inputs annotated by `lineage`.
+	Lineages map[int][2]string
+	entries  map[int][]matrixEntry
+}
+
+// ReadBrackenMatrix reads a column of Bracken files ("bracken") and Bracken
+// files merged by architeuthis ("bracken-merged").
+func ReadBrackenMatrix(files []string, column string) (*BrackenMatrix, error) {
+	m := &BrackenMatrix{Names: make(map[int]string), Ranks: make(map[int]string),
+		Lineages: make(map[int][2]string), entries: make(map[int][]matrixEntry)}
+	index := make(map[string]int)
+	for _, file := range files {
+		filetype, _ := GetFormat(file)
+		if filetype != "bracken" && filetype != "bracken-merged" {
+			return nil, fmt.Errorf("file %s is not in Bracken format", file)
+		}
+		if err := m.read(file, filetype, column, index); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *BrackenMatrix) read(file string, filetype string, column string, index map[string]int) error {
+	fi, err := OpenFile(file)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+	reader := csv.NewReader(fi)
+	if filetype == "bracken" {
+		reader.Comma = '\t'
+	}
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	col := func(name string) int { return slices.Index(header, name) }
+	tid_idx, name_idx, rank_idx, val_idx := col("taxonomy_id"), col("name"), col("taxonomy_lvl"), col(column)
+	sample_idx, lin_idx, tlin_idx := col("sample_id"), col("lineage"), col("taxid_lineage")
+	if val_idx < 0 {
+		return fmt.Errorf("%s has no `%s` column", file, column)
+	}
+
+	sample_id := SampleID(file)
+	seen := make(map[int]bool)
+	n := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			fi.Close()
 			return err
 		}
-		if i == 0 {
-			n_elems = len(header)
-			field[0] = "sample_id"
-			header = append(field, header...)
-			err = writer.Write(header)
+		if sample_idx >= 0 {
+			sample_id = record[sample_idx]
+		}
+		i, ok := index[sample_id]
+		if !ok {
+			i = len(m.Samples)
+			index[sample_id] = i
+			m.Samples = append(m.Samples, sample_id)
+			seen[i] = true
+		} else if !seen[i] {
+			return fmt.Errorf("sample %s appears more than once", sample_id)
+		}
+		taxid, err := strconv.Atoi(record[tid_idx])
+		if err != nil {
+			return fmt.Errorf("invalid taxon ID %s in %s", record[tid_idx], file)
+		}
+		value, err := strconv.ParseFloat(record[val_idx], 64)
+		if err != nil {
+			return fmt.Errorf("invalid value %s in %s", record[val_idx], file)
+		}
+		if _, ok := m.Names[taxid]; !ok {
+			m.Taxa = append(m.Taxa, taxid)
+			m.Names[taxid] = record[name_idx]
+			m.Ranks[taxid] = record[rank_idx]
+		}
+		if lin_idx >= 0 && tlin_idx >= 0 {
+			m.Lineages[taxid] = [2]string{record[lin_idx], record[tlin_idx]}
+		}
+		if value != 0 {
+			m.entries[taxid] = append(m.entries[taxid], matrixEntry{i, value})
+		}
+		n++
+	}
+	log.Printf("Read %d records from %s.", n, file)
+	return nil
+}
+
+// Unannotated returns the taxa without lineage from the inputs.
+func (m *BrackenMatrix) Unannotated() map[string]bool {
+	taxids := make(map[string]bool)
+	for _, taxid := range m.Taxa {
+		if _, ok := m.Lineages[taxid]; !ok {
+			taxids[strconv.Itoa(taxid)] = true
+		}
+	}
+	return taxids
+}
+
+// Write writes the matrix with one row for each taxon and one column for each
+// sample. Missing values are zero. With `lineage` the lineage columns of
+// annotated inputs are added, or the given lineages if the inputs have none.
+func (m *BrackenMatrix) Write(out string, lineage bool, lineages map[string]*Lineage, format *LineageFormat) (err error) {
+	merged, err := CreateFile(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := merged.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	writer := csv.NewWriter(merged)
+
+	header := []string{"taxid", "name", "rank"}
+	if lineage {
+		header = append(header, "lineage", "taxid_lineage")
+	}
+	n := len(header)
+	if err := writer.Write(append(header, m.Samples...)); err != nil {
+		return err
+	}
+
+	record := make([]string, n+len(m.Samples))
+	for _, taxid := range m.Taxa {
+		record[0], record[1], record[2] = strconv.Itoa(taxid), m.Names[taxid], m.Ranks[taxid]
+		if lineage {
+			record[3], record[4] = "", ""
+			if lin, ok := m.Lineages[taxid]; ok {
+				record[3], record[4] = lin[0], lin[1]
+			} else if lin, ok := lineages[record[0]]; ok {
+				record[3], record[4] = format.Join(lin.Names), format.Join(lin.Taxids)
+			}
+		}
+		for i := range m.Samples {
+			record[n+i] = "0"
+		}
+		for _, e := range m.entries[taxid] {
+			record[n+e.sample] = FormatValue(e.value)
+		}
+		writer.Write(record)
+	}
+	log.Printf("Wrote %d taxa for %d samples.", len(m.Taxa), len(m.Samples))
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// brackenHeader reads the header of a Bracken file or merged Bracken table.
+func brackenHeader(file string, sep rune) ([]string, error) {
+	fi, err := OpenFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+	reader := csv.NewReader(fi)
+	reader.Comma = sep
+	return reader.Read()
+}
+
+// SampleAppend merges Bracken files into a long table with a `sample_id`
+// column. The separator of each file is detected, so `sep` is ignored.
+//
+// Deprecated: use MergeBracken.
+func SampleAppend(files []string, out string, sep rune) error {
+	return MergeBracken(files, out)
+}
+
+// MergeBracken merges Bracken files ("bracken") and merged Bracken tables
+// ("bracken-merged") into a long table with a `sample_id` column. Merged
+// tables keep their sample IDs. The columns of all files are combined by name
+// and columns missing from a file are left blank, so files from different
+// Bracken versions or with lineages can be merged. Differences between the
+// files are logged.
+func MergeBracken(files []string, out string) (err error) {
+	paths := slices.Clone(files)
+	var bracken []string
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if seen[filepath.Clean(file)] {
+			return fmt.Errorf("file %s appears more than once", file)
+		}
+		seen[filepath.Clean(file)] = true
+		if filetype, _ := GetFormat(file); filetype == "bracken" {
+			bracken = append(bracken, file)
+		}
+	}
+	if _, err := SampleIDs(bracken); err != nil {
+		return err
+	}
+	headers := make([][]string, len(files))
+	seps := make([]rune, len(files))
+	columns := []string{"sample_id"}
+	for i, file := range files {
+		filetype, _ := GetFormat(file)
+		switch filetype {
+		case "bracken":
+			seps[i] = '\t'
+		case "bracken-merged":
+			seps[i] = ','
+		default:
+			return fmt.Errorf("file %s is not in Bracken format", file)
+		}
+		if file == StdStream {
+			spooled, cleanup, err := Spool(file)
 			if err != nil {
 				return err
 			}
+			defer cleanup()
+			paths[i] = spooled
 		}
-		if (i > 0) && (len(header) != n_elems) {
-			return fmt.Errorf(
-				"file %s has a different format than previous files. "+
-					"Are you sure all files have the same format?",
-				file,
-			)
+		header, err := brackenHeader(paths[i], seps[i])
+		if err != nil {
+			return err
 		}
+		headers[i] = header
+		for _, col := range header {
+			if !slices.Contains(columns, col) {
+				columns = append(columns, col)
+			}
+		}
+	}
+	sample_columns := SampleColumns()
+	for _, col := range sample_columns {
+		if !slices.Contains(columns, col) {
+			columns = append(columns, col)
+		}
+	}
+	for i, file := range files {
+		var missing []string
+		for _, col := range columns[1:] {
+			if !slices.Contains(headers[i], col) && !slices.Contains(sample_columns, col) {
+				missing = append(missing, col)
+			}
+		}
+		if len(missing) > 0 {
+			log.Printf("%s has no %v columns, which are left blank.", file, missing)
+		}
+	}
 
+	merged, err := CreateFile(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := merged.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	writer := csv.NewWriter(merged)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+
+	owner := make(map[string]int)
+	record := make([]string, len(columns))
+	for i, file := range files {
+		// The source of each column in the file and the sample sheet.
+		index := make([]int, len(columns))
+		meta := make([]int, len(columns))
+		for j, col := range columns {
+			index[j] = slices.Index(headers[i], col)
+			meta[j] = slices.Index(sample_columns, col)
+		}
+		sample_id := ""
+		if index[0] < 0 {
+			sample_id = SampleID(file)
+		}
+
+		fi, err := OpenFile(paths[i])
+		if err != nil {
+			return err
+		}
+		reader := csv.NewReader(fi)
+		reader.Comma = seps[i]
+		reader.FieldsPerRecord = -1
+		reader.Read()
 		lines := 0
-		field[0] = sample_id
 		for {
-			records, err = reader.Read()
+			row, err := reader.Read()
 			if err == io.EOF {
 				break
 			}
 			if err != nil {
-				log.Fatal(err)
+				fi.Close()
+				return err
 			}
-			records = append(field, records...)
-			err = writer.Write(records)
-			if err != nil {
+			if index[0] >= 0 {
+				sample_id = row[index[0]]
+			}
+			if other, ok := owner[sample_id]; !ok {
+				owner[sample_id] = i
+			} else if other != i {
+				fi.Close()
+				return fmt.Errorf("sample %s appears in %s and %s", sample_id, files[other], file)
+			}
+			metadata := SampleMetadata(sample_id)
+			record[0] = sample_id
+			for j := 1; j < len(columns); j++ {
+				record[j] = ""
+				if k := index[j]; k >= 0 && k < len(row) {
+					record[j] = row[k]
+				} else if k < 0 && meta[j] >= 0 {
+					record[j] = metadata[meta[j]]
+				}
+			}
+			if err := writer.Write(record); err != nil {
+				fi.Close()
 				return err
 			}
-			lines += 1
+			lines++
 		}
 		fi.Close()
 		log.Printf("Wrote %d records from %s.", lines, file)
 	}
 	writer.Flush()
 
-	return nil
+	return writer.Error()
 }
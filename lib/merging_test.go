@@ -1,8 +1,10 @@
 package lib
 
 import (
+	"encoding/csv"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 )
 
@@ -35,12 +37,174 @@ func TestComplex(t *testing.T) {
 	}
 	defer os.Remove(out.Name())
 
-	err = SampleAppend([]string{with_sample, with_sample}, out.Name(), '\t')
+	bracken := filepath.Join("..", "testdata", "test.b2")
+	other_lines, _ := CountLines(bracken)
+	err = SampleAppend([]string{with_sample, bracken}, out.Name(), '\t')
 	if err != nil {
 		t.Fatal("Complex merge failed.")
 	}
 	merged_lines, _ := CountLines(out.Name())
-	if merged_lines != 2*lines-1 {
-		t.Errorf("Input files had %d lines but merged file had %d.", lines, merged_lines)
+	if merged_lines != lines+other_lines-1 {
+		t.Errorf("Input files had %d lines but merged file had %d.", lines+other_lines, merged_lines)
+	}
+
+	if err := SampleAppend([]string{with_sample, with_sample}, out.Name(), '\t'); err == nil {
+		t.Error("Expected an error for duplicate samples.")
+	}
+}
+
+func TestMergeReports(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "A.kreport")
+	b := filepath.Join(dir, "B.kreport")
+	SimpleAppend([]string{minimizer_report}, a, false)
+	SimpleAppend([]string{named_report}, b, false)
+	lines_a, _ := CountLines(a)
+	lines_b, _ := CountLines(b)
+
+	out := filepath.Join(dir, "long.csv")
+	if err := MergeReports([]string{a, b}, out); err != nil {
+		t.Fatalf("Merging reports failed: %v", err)
+	}
+	merged_lines, _ := CountLines(out)
+	if merged_lines != lines_a+lines_b+1 {
+		t.Errorf("Input files had %d lines but merged file had %d.", lines_a+lines_b, merged_lines)
+	}
+
+	uniq := filepath.Join(dir, "U.kreport")
+	SimpleAppend([]string{filepath.Join("..", "testdata", "krakenuniq_report.tsv")}, uniq, false)
+	for _, files := range [][]string{{a, uniq}, {uniq, a}} {
+		if err := MergeReports(files, out); err != nil {
+			t.Fatalf("Merging reports failed: %v", err)
+		}
+		file, _ := os.Open(out)
+		records, err := csv.NewReader(file).ReadAll()
+		file.Close()
+		if err != nil {
+			t.Fatalf("Rows of mixed reports should have the same length: %v", err)
+		}
+		if !slices.Contains(records[0], "kmers") {
+			t.Errorf("Expected k-mer columns for %v but got %v.", files, records[0])
+		}
+	}
+
+	out = filepath.Join(dir, "wide.csv")
+	if err := MergeReportsWide([]string{a, b}, out, "clade_reads"); err != nil {
+		t.Fatalf("Merging reports failed: %v", err)
+	}
+	file, _ := os.Open(out)
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both reports share the root and the unclassified reads.
+	if len(records) != lines_a+lines_b-2+1 {
+		t.Errorf("Expected %d rows but got %d.", lines_a+lines_b-1, len(records))
+	}
+	if !slices.Equal(records[0], []string{"taxid", "name", "rank", "A", "B"}) {
+		t.Errorf("Unexpected header %v.", records[0])
+	}
+	for _, r := range records[1:] {
+		if r[0] == "134" && (r[3] != "0" || r[4] != "44110") {
+			t.Errorf("Expected 0 and 44110 reads for Streptococcus but got %v.", r[3:])
+		}
+		if r[0] == "1" && (r[3] != "900" || r[4] != "47180") {
+			t.Errorf("Expected 900 and 47180 reads for the root but got %v.", r[3:])
+		}
+	}
+
+	if err := MergeReportsWide([]string{a, a}, out, "clade_reads"); err == nil {
+		t.Error("Expected an error for duplicate samples.")
+	}
+	c := filepath.Join(dir, "C.kreport")
+	os.WriteFile(c, []byte("100.00\t10\t10\tD\t10239\tViruses\n"), 0o644)
+	if err := MergeReportsWide([]string{a, c}, out, "clade_reads"); err == nil {
+		t.Error("Expected an error for reports with different roots.")
+	}
+}
+
+func TestBrackenMatrix(t *testing.T) {
+	bracken := filepath.Join("..", "testdata", "test.b2")
+	matrix, err := ReadBrackenMatrix([]string{with_sample, bracken}, "new_est_reads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(matrix.Samples, []string{"S_positive_1", "test"}) {
+		t.Errorf("Expected samples S_positive_1 and test but got %v.", matrix.Samples)
+	}
+	if _, err := ReadBrackenMatrix([]string{bracken, bracken}, "new_est_reads"); err == nil {
+		t.Error("Expected an error for duplicate samples.")
+	}
+
+	out := filepath.Join(t.TempDir(), "matrix.csv")
+	lineages := AddLineage(matrix.Unannotated(), DetectTaxonomy(taxdump), MustParseFormat("{K};{p};{c};{o};{f};{g};{s}"))
+	err = matrix.Write(out, true, lineages, MustParseFormat("{K};{p};{c};{o};{f};{g};{s}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, _ := os.Open(out)
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(records[0], []string{"taxid", "name", "rank", "lineage", "taxid_lineage", "S_positive_1", "test"}) {
+		t.Errorf("Unexpected header %v.", records[0])
+	}
+	if len(records) != len(matrix.Taxa)+1 {
+		t.Errorf("Expected %d rows but got %d.", len(matrix.Taxa)+1, len(records))
+	}
+	for _, r := range records[1:] {
+		if r[0] == "816" && (r[6] != "2222764" || r[5] != "0" || r[4] != "2;976;200643;171549;815;816;") {
+			t.Errorf("Unexpected row %v.", r)
+		}
+	}
+}
+
+func TestMergeBracken(t *testing.T) {
+	dir := t.TempDir()
+	bracken := filepath.Join("..", "testdata", "test.b2")
+	annotated := filepath.Join(dir, "annotated.csv")
+	os.WriteFile(annotated, []byte("sample_id,name,taxonomy_id,taxonomy_lvl,kraken_assigned_reads,"+
+		"added_reads,new_est_reads,fraction_total_reads,lineage\n"+
+		"A,Bacteroides,816,G,10,5,15,1.0,k__Bacteria;g__Bacteroides\n"), 0644)
+
+	out := filepath.Join(dir, "merged.csv")
+	if err := MergeBracken([]string{annotated, bracken}, out); err != nil {
+		t.Fatal(err)
+	}
+	if format, lineage := GetFormat(out); format != "bracken-merged" || lineage {
+		t.Errorf("Expected format bracken-merged without taxid lineage but got %s.", format)
+	}
+	file, _ := os.Open(out)
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, _ := CountLines(bracken)
+	if len(records) != lines+1 || records[0][len(records[0])-1] != "lineage" {
+		t.Errorf("Expected %d records with a lineage column but got %d with %v.", lines+1, len(records), records[0])
+	}
+	if records[1][0] != "A" || records[1][8] != "k__Bacteria;g__Bacteroides" ||
+		records[2][0] != "test" || records[2][8] != "" {
+		t.Errorf("Unexpected records %v and %v.", records[1], records[2])
+	}
+
+	if err := MergeBracken([]string{out, bracken}, filepath.Join(dir, "again.csv")); err == nil {
+		t.Error("Expected an error for samples in several files.")
+	}
+
+	for _, files := range [][]string{{bracken, bracken}, {with_sample, "./" + with_sample}} {
+		if err := MergeBracken(files, out); err == nil {
+			t.Errorf("Expected an error for duplicate samples in %v.", files)
+		}
+	}
+	renamed := filepath.Join(dir, "test.b2")
+	data, _ := os.ReadFile(bracken)
+	os.WriteFile(renamed, data, 0644)
+	if err := MergeBracken([]string{bracken, renamed}, out); err == nil {
+		t.Error("Expected an error for files with the same sample ID.")
 	}
 }
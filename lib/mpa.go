@@ -0,0 +1,205 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MPATable holds the values of MetaPhlAn-style clades such as
+// `k__Bacteria|p__Bacillota` for each sample.
+type MPATable struct {
+	Samples []string
+	Values  map[string]map[string]float64
+}
+
+// mpaClade joins the prefixed names of a clade's lineage up to its rank.
+// Missing ranks are skipped and spaces are replaced like in kreport2mpa.
+func mpaClade(clade CladeValue) string {
+	names := make([]string, 0, clade.Rank+1)
+	for i := 0; i <= clade.Rank; i++ {
+		if clade.Lineage.Present[i] {
+			names = append(names, strings.ReplaceAll(clade.Lineage.Names[i], " ", "_"))
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// NewMPATable sums up counts on the clades of a lineage format. The lineage
+// format should add the rank prefixes. With `percentages` the values are
+// relative to the sum of all values in a sample.
+func NewMPATable(counts *Counts, lineages map[string]*Lineage, format *LineageFormat, percentages bool) *MPATable {
+	table := &MPATable{Values: make(map[string]map[string]float64)}
+	for _, s := range counts.Samples {
+		total := 0.0
+		for _, v := range counts.Values[s] {
+			total += v
+		}
+		values := make(map[string]float64)
+		for _, clade := range CladeValues(counts.Values[s], lineages, format.Ranks) {
+			if percentages {
+				clade.Value = 100 * clade.Value / total
+			}
+			values[mpaClade(clade)] += clade.Value
+		}
+		table.Samples = append(table.Samples, s)
+		table.Values[s] = values
+	}
+	return table
+}
+
+// ReadMPA reads a table in mpa format. This may be the output of
+// `kreport2mpa.py`, `combine_mpa.py` or MetaPhlAn. Files without a header use
+// the file name as sample ID.
+func ReadMPA(filename string) (*MPATable, error) {
+	file, err := OpenFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	table := &MPATable{Values: make(map[string]map[string]float64)}
+	samples := []string{SampleID(filename)}
+	columns := []int{1}
+	header := true
+	addSamples := func() error {
+		header = false
+		for _, s := range samples {
+			if _, ok := table.Values[s]; ok {
+				return fmt.Errorf("sample %s appears more than once in %s", s, filename)
+			}
+			table.Samples = append(table.Samples, s)
+			table.Values[s] = make(map[string]float64)
+		}
+		return nil
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Split(line, "\t")
+		if strings.HasPrefix(line, "#") {
+			// Other comments such as the MetaPhlAn version are skipped.
+			if !header {
+				continue
+			}
+			switch {
+			case fields[0] == "#Classification":
+				samples, columns = fields[1:], nil
+				for i := range samples {
+					columns = append(columns, i+1)
+				}
+			case fields[0] == "#clade_name":
+				if i := slices.Index(fields, "relative_abundance"); i > 0 {
+					columns = []int{i}
+				}
+			}
+			continue
+		}
+		if header {
+			if err := addSamples(); err != nil {
+				return nil, err
+			}
+		}
+		if line == "" {
+			continue
+		}
+		for j, c := range columns {
+			if c >= len(fields) {
+				return nil, fmt.Errorf("missing value for %s in %s", fields[0], filename)
+			}
+			value, err := strconv.ParseFloat(fields[c], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %s for %s in %s", fields[c], fields[0], filename)
+			}
+			table.Values[samples[j]][fields[0]] = value
+		}
+	}
+	if header {
+		if err := addSamples(); err != nil {
+			return nil, err
+		}
+	}
+	return table, scanner.Err()
+}
+
+// Merge adds the samples of other to the table. Samples may only appear once.
+func (m *MPATable) Merge(other *MPATable) error {
+	for _, s := range other.Samples {
+		if _, ok := m.Values[s]; ok {
+			return fmt.Errorf("sample %s appears more than once", s)
+		}
+		m.Samples = append(m.Samples, s)
+		m.Values[s] = other.Values[s]
+	}
+	return nil
+}
+
+// Write writes the table with one row for each clade and one column for each
+// sample like `combine_mpa.py`. Clades missing from a sample are zero.
+func (m *MPATable) Write(out string) (err error) {
+	file, err := CreateFile(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	writer := bufio.NewWriter(file)
+
+	seen := make(map[string]bool)
+	var clades []string
+	for _, s := range m.Samples {
+		for clade := range m.Values[s] {
+			if !seen[clade] {
+				seen[clade] = true
+				clades = append(clades, clade)
+			}
+		}
+	}
+	slices.Sort(clades)
+
+	writer.WriteString("#Classification\t" + strings.Join(m.Samples, "\t") + "\n")
+	for _, clade := range clades {
+		writer.WriteString(clade)
+		for _, s := range m.Samples {
+			writer.WriteString("\t" + FormatValue(m.Values[s][clade]))
+		}
+		writer.WriteString("\n")
+	}
+	return writer.Flush()
+}
+
+// MergeMPA merges mpa tables into a single table.
+func MergeMPA(files []string, out string) error {
+	merged := &MPATable{Values: make(map[string]map[string]float64)}
+	for _, filename := range files {
+		table, err := ReadMPA(filename)
+		if err != nil {
+			return err
+		}
+		if err := merged.Merge(table); err != nil {
+			return err
+		}
+	}
+	return merged.Write(out)
+}
@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMPA(t *testing.T) {
+	format := MustParseFormat("{K};{p};{c};{o};{f};{g};{s}")
+	counts, _ := ReadCounts(minimizer_report, "report", "")
+	lineages := CountLineages(counts, DetectTaxonomy(taxdump), format)
+	table := NewMPATable(counts, lineages, format, false)
+	values := table.Values["minimizer_report"]
+	if values["k__Bacteria"] != 890 ||
+		values["k__Bacteria|p__Pseudomonadota|c__Gammaproteobacteria|o__Enterobacterales|"+
+			"f__Enterobacteriaceae|g__Escherichia|s__Escherichia_coli"] != 270 {
+		t.Errorf("Unexpected clade values %v.", values)
+	}
+	percentages := NewMPATable(counts, lineages, format, true)
+	if v := percentages.Values["minimizer_report"]["k__Bacteria|p__Bacteroidota"]; v != 100*600.0/900 {
+		t.Errorf("Expected a percentage of %g but got %g.", 100*600.0/900, v)
+	}
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "A.mpa.txt")
+	if err := table.Write(out); err != nil {
+		t.Fatal(err)
+	}
+	if format, _ := GetFormat(out); format != "mpa" {
+		t.Errorf("Expected format mpa but got %s.", format)
+	}
+	metaphlan := filepath.Join(dir, "B.txt")
+	os.WriteFile(metaphlan, []byte("#mpa_vJan21_CHOCOPhlAnSGB_202103\n#1000 reads processed\n"+
+		"#clade_name\tNCBI_tax_id\trelative_abundance\tadditional_species\n"+
+		"k__Bacteria\t2\t100.0\t\nk__Bacteria|p__Bacillota\t2|1239\t100.0\t\n"), 0644)
+
+	merged := filepath.Join(dir, "merged.txt")
+	if err := MergeMPA([]string{out, metaphlan}, merged); err != nil {
+		t.Fatal(err)
+	}
+	result, err := ReadMPA(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.Samples, []string{"minimizer_report", "B"}) {
+		t.Errorf("Expected samples minimizer_report and B but got %v.", result.Samples)
+	}
+	if result.Values["B"]["k__Bacteria|p__Bacillota"] != 100 ||
+		result.Values["minimizer_report"]["k__Bacteria|p__Bacillota"] != 0 ||
+		result.Values["minimizer_report"]["k__Bacteria"] != 890 {
+		t.Errorf("Unexpected merged values %v.", result.Values)
+	}
+	if err := MergeMPA([]string{out, out}, merged); err == nil {
+		t.Error("Expected an error for duplicate samples.")
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CAMIVersion is the version of the CAMI profiling format.
+const CAMIVersion = "0.9.1"
+
+// CAMIRanks are the CAMI rank names of the format symbols. CAMI names domains
+// "superkingdom" in both the NCBI and GTDB taxonomies.
+var CAMIRanks = map[string]string{
+	"K": "superkingdom", "d": "superkingdom", "k": "kingdom", "p": "phylum", "c": "class",
+	"o": "order", "f": "family", "g": "genus", "s": "species", "t": "strain",
+	"S": "subspecies", "T": "strain",
+}
+
+// CladeValue is the cumulative value of a clade on one rank of a lineage
+// format.
+type CladeValue struct {
+	// The index of the rank in the format.
+	Rank  int
+	Taxid string
+	// The lineage of a taxon in the clade. Its entries up to Rank are the
+	// lineage of the clade.
+	Lineage *Lineage
+	Value   float64
+}
+
+// CladeValues sums the values of taxa onto the clades they belong to on each
+// rank of a lineage format. Taxa without lineage are skipped and the results
+// are ordered by rank and decreasing value.
+func CladeValues(values map[int]float64, lineages map[string]*Lineage, ranks []string) []CladeValue {
+	index := make(map[string]int)
+	var clades []CladeValue
+	for taxid, value := range values {
+		lin := lineages[strconv.Itoa(taxid)]
+		if lin == nil || lin.Taxid == "" {
+			continue
+		}
+		for i := range ranks {
+			if !lin.Present[i] || lin.Taxids[i] == "" {
+				continue
+			}
+			key := strconv.Itoa(i) + ":" + lin.Taxids[i]
+			if j, ok := index[key]; ok {
+				clades[j].Value += value
+				continue
+			}
+			index[key] = len(clades)
+			clades = append(clades, CladeValue{Rank: i, Taxid: lin.Taxids[i], Lineage: lin, Value: value})
+		}
+	}
+	slices.SortFunc(clades, func(a, b CladeValue) int {
+		if a.Rank != b.Rank {
+			return a.Rank - b.Rank
+		}
+		if a.Value != b.Value {
+			if a.Value > b.Value {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Taxid, b.Taxid)
+	})
+	return clades
+}
+
+// WriteCAMI writes a CAMI taxonomic profile for each sample. Percentages are
+// relative to the sum of all values in a sample. The taxonomy ID is written
+// to the `@TaxonomyID` header, for instance "ncbi-taxonomy".
+func WriteCAMI(counts *Counts, lineages map[string]*Lineage, format *LineageFormat,
+	taxonomy_id string, out string) (err error) {
+	file, err := CreateFile(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	writer := bufio.NewWriter(file)
+
+	ranks := make([]string, len(format.Ranks))
+	for i, r := range format.Ranks {
+		ranks[i] = CAMIRanks[r]
+	}
+	for _, s := range counts.Samples {
+		total := 0.0
+		for _, v := range counts.Values[s] {
+			total += v
+		}
+		fmt.Fprintf(writer, "# Taxonomic Profiling Output\n@SampleID:%s\n@Version:%s\n", s, CAMIVersion)
+		fmt.Fprintf(writer, "@Ranks:%s\n@TaxonomyID:%s\n", strings.Join(ranks, "|"), taxonomy_id)
+		writer.WriteString("@@TAXID\tRANK\tTAXPATH\tTAXPATHSN\tPERCENTAGE\n")
+		for _, clade := range CladeValues(counts.Values[s], lineages, format.Ranks) {
+			lin := clade.Lineage
+			names := make([]string, clade.Rank+1)
+			for i := range names {
+				if lin.Present[i] {
+					names[i] = strings.TrimPrefix(lin.Names[i], RankPrefixes[format.Ranks[i]])
+				}
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", clade.Taxid, ranks[clade.Rank],
+				strings.Join(lin.Taxids[:clade.Rank+1], "|"), strings.Join(names, "|"),
+				FormatValue(100*clade.Value/total))
+		}
+		writer.WriteString("\n")
+	}
+	return writer.Flush()
+}
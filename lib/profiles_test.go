@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCladeValues(t *testing.T) {
+	format := MustParseFormat("{K};{p};{c};{o};{f};{g};{s}")
+	counts, err := ReadCounts(minimizer_report, "report", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lineages := CountLineages(counts, DetectTaxonomy(taxdump), format)
+	clades := CladeValues(counts.Values["minimizer_report"], lineages, format.Ranks)
+	found := make(map[string]float64)
+	for i, c := range clades {
+		if i > 0 && c.Rank < clades[i-1].Rank {
+			t.Errorf("Expected clades ordered by rank but %s comes after %s.", c.Taxid, clades[i-1].Taxid)
+		}
+		found[c.Taxid] = c.Value
+	}
+	expected := map[string]float64{"2": 890, "976": 600, "816": 400, "562": 270}
+	for taxid, v := range expected {
+		if found[taxid] != v {
+			t.Errorf("Expected %g reads for clade %s but got %g.", v, taxid, found[taxid])
+		}
+	}
+	if _, ok := found["1"]; ok {
+		t.Error("Expected no clade for the root.")
+	}
+}
+
+func TestCAMI(t *testing.T) {
+	format := MustParseFormat("{K};{p};{c};{o};{f};{g};{s}")
+	counts, _ := ReadCounts(minimizer_report, "report", "")
+	lineages := CountLineages(counts, DetectTaxonomy(taxdump), format)
+	out := filepath.Join(t.TempDir(), "profile.cami")
+	if err := WriteCAMI(counts, lineages, format, "ncbi-taxonomy", out); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(out)
+	profile := string(data)
+	if !strings.Contains(profile, "@SampleID:minimizer_report\n") ||
+		!strings.Contains(profile, "@Ranks:superkingdom|phylum|class|order|family|genus|species\n") {
+		t.Errorf("Unexpected CAMI header in %s.", profile)
+	}
+	line := "562\tspecies\t2|1224|1236|91347|543|561|562\t" +
+		"Bacteria|Pseudomonadota|Gammaproteobacteria|Enterobacterales|Enterobacteriaceae|Escherichia|Escherichia coli\t30\n"
+	if !strings.Contains(profile, line) {
+		t.Errorf("Expected the line %q in the profile.", line)
+	}
+}
+
+func TestCAMIStrains(t *testing.T) {
+	format := MustParseFormat("{d};{p};{c};{o};{f};{g};{s};{t}")
+	counts := &Counts{Samples: []string{"A"}, Values: map[string]map[int]float64{"A": {83333: 10}}}
+	lineages := CountLineages(counts, DetectTaxonomy(taxdump), format)
+	out := filepath.Join(t.TempDir(), "profile.cami")
+	if err := WriteCAMI(counts, lineages, format, "ncbi-taxonomy", out); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(out)
+	profile := string(data)
+	if !strings.Contains(profile, "@Ranks:superkingdom|phylum|class|order|family|genus|species|strain\n") {
+		t.Errorf("Unexpected CAMI ranks in %s.", profile)
+	}
+	if !strings.Contains(profile, "\n83333\tstrain\t2|1224|1236|91347|543|561|562|83333\t") {
+		t.Errorf("Expected a strain entry in %s.", profile)
+	}
+}
@@ -0,0 +1,302 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// TaxonomyProvider resolves taxon IDs against a taxonomy. All lineage
+// annotation in architeuthis goes through this interface, so you can
+// implement it to use your own taxonomy store.
+type TaxonomyProvider interface {
+	// Lineages returns the lineages of the taxon IDs on the ranks of the
+	// format. Every taxon ID must have an entry in the result.
+	Lineages(taxids []string, format string) (map[string]*Lineage, error)
+	// Parent returns the taxon ID of the parent. This is false for the root
+	// and unknown taxa.
+	Parent(taxid int) (int, bool)
+	Name(taxid int) (string, bool)
+	Rank(taxid int) (string, bool)
+	// Children returns the taxon IDs of the direct children.
+	Children(taxid int) []int
+}
+
+// AncestorPath is the path from the root of a taxonomy down to a taxon.
+type AncestorPath struct {
+	// The current taxon ID, 0 for deleted taxa.
+	Taxid   int
+	Merged  bool
+	Deleted bool
+	// The taxon IDs, names and ranks from the root to the taxon.
+	Taxids []int
+	Names  []string
+	Ranks  []string
+}
+
+// AncestorLookup is implemented by taxonomies that can look up the ancestors
+// of many taxa at once. Subtree uses it instead of walking up with Parent.
+type AncestorLookup interface {
+	// Ancestors returns the paths from the root to the taxa. Unknown taxa are
+	// missing from the result.
+	Ancestors(taxids []int) (map[int]*AncestorPath, error)
+}
+
+// NameLookup is implemented by taxonomies that can find taxa by name.
+type NameLookup interface {
+	// TaxidsByName returns the taxon IDs with the given scientific name. Names
+	// are matched ignoring case.
+	TaxidsByName(name string) []int
+}
+
+// The supported taxonomy backends.
+const (
+	BackendAuto     = "auto"
+	BackendTaxdump  = "taxdump"
+	BackendKraken   = "kraken"
+	BackendGTDB     = "gtdb"
+	BackendTaxonkit = "taxonkit"
+)
+
+// Backends lists the backends that can be passed to NewTaxonomyProvider.
+var Backends = []string{BackendAuto, BackendTaxdump, BackendKraken, BackendGTDB, BackendTaxonkit}
+
+// FileTaxonomy is a taxonomy read from local files, either an NCBI taxonomy
+// dump, the `taxo.k2d` file of a Kraken2 database, or GTDB taxonomy tables.
+// Lineages are served from cached taxonomy indices.
+type FileTaxonomy struct {
+	Backend string
+	Path    string
+
+	navOnce sync.Once
+	nav     *TaxonomyIndex
+}
+
+// DetectTaxonomy guesses the backend for a taxonomy location. An empty
+// location means the default taxonomy dump (see DefaultDataDir).
+func DetectTaxonomy(location string) *FileTaxonomy {
+	if location == "" {
+		return &FileTaxonomy{Backend: BackendTaxdump, Path: DefaultDataDir()}
+	}
+	if strings.HasSuffix(location, ".k2d") {
+		return &FileTaxonomy{Backend: BackendKraken, Path: location}
+	}
+	if k2d := KrakenTaxonomyFile(location); k2d != "" {
+		return &FileTaxonomy{Backend: BackendKraken, Path: k2d}
+	}
+	if !hasTaxdump(location) && IsGTDB(location) {
+		return &FileTaxonomy{Backend: BackendGTDB, Path: location}
+	}
+	return &FileTaxonomy{Backend: BackendTaxdump, Path: location}
+}
+
+// NewTaxonomyProvider creates the provider for a backend and location.
+func NewTaxonomyProvider(backend string, location string) (TaxonomyProvider, error) {
+	switch backend {
+	case BackendAuto:
+		return DetectTaxonomy(location), nil
+	case BackendTaxdump:
+		if location == "" {
+			location = DefaultDataDir()
+		}
+		if !hasTaxdump(location) {
+			return nil, fmt.Errorf("no nodes.dmp found in %s", location)
+		}
+		return &FileTaxonomy{Backend: backend, Path: location}, nil
+	case BackendKraken:
+		if !strings.HasSuffix(location, ".k2d") {
+			location = KrakenTaxonomyFile(location)
+		}
+		if location == "" {
+			return nil, errors.New("the Kraken2 backend requires a database with a taxo.k2d file")
+		}
+		return &FileTaxonomy{Backend: backend, Path: location}, nil
+	case BackendGTDB:
+		if !IsGTDB(location) {
+			return nil, fmt.Errorf("no GTDB taxonomy tables and %s found in `%s`",
+				GTDBTaxidFile, location)
+		}
+		return &FileTaxonomy{Backend: backend, Path: location}, nil
+	case BackendTaxonkit:
+		if _, ok := HasTaxonkit(); !ok {
+			return nil, errors.New("no taxonkit installation could be found :(")
+		}
+		return &TaxonkitProvider{DataDir: location}, nil
+	}
+	return nil, fmt.Errorf("unknown taxonomy backend `%s`", backend)
+}
+
+// LoadTaxonomy reads the taxonomy tree from a Kraken2 `taxo.k2d` file, a
+// Kraken2 database containing one, an NCBI taxonomy dump directory, or a
+// directory with GTDB taxonomy tables.
+func LoadTaxonomy(data_dir string) (*Tree, error) {
+	return DetectTaxonomy(data_dir).Load()
+}
+
+// Load reads the full taxonomy tree.
+func (f *FileTaxonomy) Load() (*Tree, error) {
+	switch f.Backend {
+	case BackendKraken:
+		return LoadKrakenTaxonomy(f.Path)
+	case BackendGTDB:
+		return LoadGTDB(f.Path)
+	}
+	return LoadTaxdump(f.Path)
+}
+
+// Sources lists the files the taxonomy is read from.
+func (f *FileTaxonomy) Sources() []string {
+	switch f.Backend {
+	case BackendKraken:
+		return []string{f.Path}
+	case BackendGTDB:
+		tables, mapping := GTDBFiles(f.Path)
+		return append(tables, mapping)
+	}
+	sources := []string{
+		filepath.Join(f.Path, "nodes.dmp"),
+		filepath.Join(f.Path, "names.dmp"),
+	}
+	for _, optional := range []string{"merged.dmp", "delnodes.dmp"} {
+		path := filepath.Join(f.Path, optional)
+		if _, err := os.Stat(path); err == nil {
+			sources = append(sources, path)
+		}
+	}
+	return sources
+}
+
+// Checksum calculates a SHA256 checksum over all taxonomy source files. The
+// checksum is cached by the path, size and modification time of the files, so
+// the files are only hashed again when one of them changes.
+func (f *FileTaxonomy) Checksum() (string, error) {
+	stamp := ""
+	for _, path := range f.Sources() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		abs, _ := filepath.Abs(path)
+		stamp += fmt.Sprintf("%s\t%d\t%d\n", abs, info.Size(), info.ModTime().UnixNano())
+	}
+	cached := ""
+	if dir := IndexDir(); dir != "" {
+		key := sha256.Sum256([]byte(stamp))
+		cached = filepath.Join(dir, hex.EncodeToString(key[:8])+".sum")
+		if data, err := os.ReadFile(cached); err == nil && len(data) == 2*sha256.Size {
+			return string(data), nil
+		}
+	}
+
+	hash := sha256.New()
+	for _, path := range f.Sources() {
+		file, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if cached != "" {
+		if err := os.MkdirAll(filepath.Dir(cached), 0o755); err == nil {
+			os.WriteFile(cached, []byte(checksum), 0o644)
+		}
+	}
+	return checksum, nil
+}
+
+// Lineages returns the lineages for the taxon IDs from the cached index.
+// Indices only depend on the ranks of the format, not its separators.
+func (f *FileTaxonomy) Lineages(taxids []string, format string) (map[string]*Lineage, error) {
+	parsed, err := ParseFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := OpenIndex(f, parsed.RankFormat())
+	if err != nil {
+		return nil, err
+	}
+	f.navOnce.Do(func() { f.nav = idx })
+
+	results := make(map[string]*Lineage, len(taxids))
+	for _, taxid := range taxids {
+		results[taxid] = idx.Lineage(taxid)
+	}
+	return results, nil
+}
+
+// navigation returns an index to look up single taxa. This reuses any index
+// that was already opened.
+func (f *FileTaxonomy) navigation() *TaxonomyIndex {
+	f.navOnce.Do(func() {
+		idx, err := OpenIndex(f, "")
+		if err != nil {
+			log.Fatalf("could not read the taxonomy: %v", err)
+		}
+		f.nav = idx
+	})
+	return f.nav
+}
+
+func (f *FileTaxonomy) Parent(taxid int) (int, bool) {
+	return f.navigation().Parent(taxid)
+}
+
+func (f *FileTaxonomy) Name(taxid int) (string, bool) {
+	return f.navigation().Name(taxid)
+}
+
+func (f *FileTaxonomy) Rank(taxid int) (string, bool) {
+	return f.navigation().Rank(taxid)
+}
+
+func (f *FileTaxonomy) Children(taxid int) []int {
+	return f.navigation().Children(taxid)
+}
+
+func (f *FileTaxonomy) LCA(taxids ...int) (int, bool) {
+	return f.navigation().LCA(taxids...)
+}
+
+func (f *FileTaxonomy) Distance(a int, b int) (int, bool) {
+	return f.navigation().Distance(a, b)
+}
+
+func (f *FileTaxonomy) IsAncestor(ancestor int, taxid int) bool {
+	return f.navigation().IsAncestor(ancestor, taxid)
+}
+
+func (f *FileTaxonomy) AncestorAt(taxid int, rank string) (int, bool) {
+	return f.navigation().AncestorAt(taxid, rank)
+}
+
+func (f *FileTaxonomy) TaxidsByName(name string) []int {
+	return f.navigation().TaxidsByName(name)
+}
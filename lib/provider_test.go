@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestProviders(t *testing.T) {
+	t.Setenv("ARCHITEUTHIS_CACHE", t.TempDir())
+	tree, _ := LoadTaxdump(taxdump)
+	providers := map[string]TaxonomyProvider{
+		"tree":    tree,
+		"taxdump": DetectTaxonomy(taxdump),
+		"kraken":  DetectTaxonomy(filepath.Join("..", "testdata", "k2db")),
+	}
+
+	for name, p := range providers {
+		if parent, ok := p.Parent(821); !ok || parent != 909656 {
+			t.Errorf("%s: expected parent %d but got %d.", name, 909656, parent)
+		}
+		if _, ok := p.Parent(1); ok {
+			t.Errorf("%s: root should not have a parent.", name)
+		}
+		if n, ok := p.Name(816); !ok || n != "Bacteroides" {
+			t.Errorf("%s: expected name %s but got %s.", name, "Bacteroides", n)
+		}
+		if r, ok := p.Rank(83333); !ok || r != "strain" {
+			t.Errorf("%s: expected rank %s but got %s.", name, "strain", r)
+		}
+		if _, ok := p.Name(123456789); ok {
+			t.Errorf("%s: found a name for an unknown taxon.", name)
+		}
+		children := p.Children(815)
+		slices.Sort(children)
+		if !slices.Equal(children, []int{816, 909656}) {
+			t.Errorf("%s: got wrong children %v.", name, children)
+		}
+		lins, err := p.Lineages([]string{"820", "0"}, "{f};{g};{s}")
+		if err != nil || len(lins) != 2 {
+			t.Fatalf("%s: could not get lineages: %v", name, err)
+		}
+		if lins["820"].Names[2] != "s__Bacteroides uniformis" {
+			t.Errorf("%s: got wrong lineage %v.", name, lins["820"].Names)
+		}
+	}
+}
+
+func TestNewTaxonomyProvider(t *testing.T) {
+	gtdb := filepath.Join("..", "testdata", "gtdb")
+	p, err := NewTaxonomyProvider(BackendAuto, gtdb)
+	if err != nil || p.(*FileTaxonomy).Backend != BackendGTDB {
+		t.Errorf("Expected the GTDB backend for %s.", gtdb)
+	}
+	if _, err := NewTaxonomyProvider(BackendKraken, taxdump); err == nil {
+		t.Error("Expected an error for a Kraken2 backend without taxo.k2d.")
+	}
+	if _, err := NewTaxonomyProvider(BackendGTDB, taxdump); err == nil {
+		t.Error("Expected an error for a GTDB backend without GTDB tables.")
+	}
+	if _, err := NewTaxonomyProvider("unknown", taxdump); err == nil {
+		t.Error("Expected an error for an unknown backend.")
+	}
+}
+
+// fakeTaxonkit puts a taxonkit script on the path that prints canned output
+// for `taxonkit lineage` and `taxonkit reformat`.
+func fakeTaxonkit(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\ncase \"$1\" in\n" +
+		"lineage) printf '" +
+		"562\\t562\\tcellular organisms;Bacteria;Escherichia;Escherichia coli\\t131567;2;561;562\\tno rank;superkingdom;genus;species\\n" +
+		"1000002\\t562\\tcellular organisms;Bacteria;Escherichia;Escherichia coli\\t131567;2;561;562\\tno rank;superkingdom;genus;species\\n" +
+		"1000003\\t0\\t\\t\\t\\n999\\t-1\\t\\t\\t\\n' ;;\n" +
+		"reformat) printf '562\\tk__Bacteria;g__Escherichia;s__Escherichia coli\\t2;561;562\\n' ;;\n" +
+		"esac\n"
+	if err := os.WriteFile(filepath.Join(dir, "taxonkit"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestTaxonkitLineages(t *testing.T) {
+	fakeTaxonkit(t)
+	tk := &TaxonkitProvider{}
+	lineages, err := tk.Lineages([]string{"562", "1000002", "1000003", "999"}, "{K};{g};{s}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lin := lineages["1000002"]; lin.Taxid != "562" || !lin.Merged || lin.Names[2] != "s__Escherichia coli" {
+		t.Errorf("Expected the merged taxon to resolve to 562 but got %+v.", lin)
+	}
+	if lin := lineages["562"]; lin.Taxid != "562" || lin.Merged || !slices.Equal(lin.Taxids, []string{"2", "561", "562"}) {
+		t.Errorf("Unexpected lineage %+v.", lin)
+	}
+	if lin := lineages["1000003"]; lin.Taxid != "" || !lin.Deleted {
+		t.Errorf("Expected a deleted taxon but got %+v.", lin)
+	}
+	if lin := lineages["999"]; lin.Taxid != "" || lin.Present[0] {
+		t.Errorf("Expected an empty lineage but got %+v.", lin)
+	}
+	if _, err := tk.Lineages([]string{"562"}, "{x}"); err == nil {
+		t.Error("Expected an error for an invalid format.")
+	}
+	if parent, ok := tk.Parent(561); ok || parent != 0 {
+		t.Errorf("Unknown taxa should not have a parent but got %d.", parent)
+	}
+	if parent, _ := tk.Parent(562); parent != 561 {
+		t.Errorf("Expected parent %d but got %d.", 561, parent)
+	}
+	if name, _ := tk.Name(1000002); name != "Escherichia coli" {
+		t.Errorf("Expected Escherichia coli but got %s.", name)
+	}
+}
+
+func TestTaxonkitSubtree(t *testing.T) {
+	fakeTaxonkit(t)
+	tk := &TaxonkitProvider{}
+	tree, err := Subtree(tk, []int{562, 1000002, 1000003, 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Root == nil || tree.Root.Taxid != 1 || len(tree.Taxids) != 5 {
+		t.Fatalf("Expected a tree with 5 taxa below the root but got %v.", tree.Taxids)
+	}
+	if tree.Merged[1000002] != 562 || !tree.Deleted[1000003] {
+		t.Errorf("Expected merged and deleted taxa but got %v and %v.", tree.Merged, tree.Deleted)
+	}
+	if genus, _ := tree.AncestorAt(1000002, "genus"); genus != 561 {
+		t.Errorf("Expected the genus %d but got %d.", 561, genus)
+	}
+
+	db := AddLineage(map[string]bool{"562": true, "1000002": true}, tk, MustParseFormat("{K};{g};{s}"))
+	score := ScoreRead("C\tr1\t1000002\t100\t562:10 1000002:5", db, treeTaxonomy(tk, db), false)
+	if score == nil || score.RemappedID != 562 || score.Consistency != 1 {
+		t.Errorf("Expected a consistent read remapped to 562 but got %+v.", score)
+	}
+}
@@ -0,0 +1,227 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// reportRanks maps the rank codes of Kraken2 reports to ranks. Codes with a
+// number such as "G1" are taxa without a rank between two ranks.
+var reportRanks = map[string]string{
+	"R": "no rank", "D": "superkingdom", "K": "kingdom", "P": "phylum",
+	"C": "class", "O": "order", "F": "family", "G": "genus", "S": "species",
+}
+
+// reportCode returns the Kraken rank code for a rank. Taxa without one of the
+// main ranks get "-" like in KrakenUniq and Kraken 1 reports.
+func reportCode(rank string) string {
+	for code, r := range reportRanks {
+		if r == rank && code != "R" {
+			return code
+		}
+	}
+	return "-"
+}
+
+// Report is a Kraken2 or KrakenUniq report. The tree contains the classified
+// taxa with their clade counts as values and keeps the order of the report.
+type Report struct {
+	Tree *Tree
+	// The reads classified directly to each taxon.
+	Direct map[int]float64
+	// The rank codes of the report such as "S" or "G1".
+	Codes map[int]string
+	// The minimizers and distinct minimizers of each taxon. Those are only set
+	// for reports created with `--report-minimizer-data`.
+	Minimizers         map[int]uint64
+	DistinctMinimizers map[int]uint64
+	// The unique k-mers, their duplication and the coverage of the clade in the
+	// database. Those are only set for KrakenUniq reports and missing coverages
+	// are NaN.
+	Kmers        map[int]float64
+	Duplication  map[int]float64
+	Coverage     map[int]float64
+	Unclassified float64
+}
+
+// ReportRank returns the rank for a Kraken2 report rank code.
+func ReportRank(code string) string {
+	if rank, ok := reportRanks[code]; ok {
+		return rank
+	}
+	return "no rank"
+}
+
+// ReadReport reads a Kraken2 report. This supports the standard 6-column
+// reports, the 8-column reports with minimizer data and KrakenUniq reports.
+// The taxonomy tree is built from the indentation of the names.
+func ReadReport(filename string) (*Report, error) {
+	file, err := OpenFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	report := &Report{
+		Tree:   &Tree{Taxids: make(map[int]*Node)},
+		Direct: make(map[int]float64),
+		Codes:  make(map[int]string),
+	}
+	var stack []*Node
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "%\t") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		var minimizers, kmers []string
+		rank := ""
+		switch len(fields) {
+		case 6:
+		case 8:
+			minimizers = []string{fields[3], fields[4]}
+			fields = append(fields[:3], fields[5:]...)
+		case 9:
+			kmers = []string{fields[3], fields[4], fields[5]}
+			rank = strings.TrimSpace(fields[7])
+			fields = []string{fields[0], fields[1], fields[2], reportCode(rank), fields[6], fields[8]}
+		default:
+			return nil, fmt.Errorf("line %d of %s is not in Kraken2 report format", n, filename)
+		}
+
+		clade, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid clade count on line %d of %s", n, filename)
+		}
+		direct, err := strconv.ParseFloat(strings.TrimSpace(fields[2]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid count on line %d of %s", n, filename)
+		}
+		code := strings.TrimSpace(fields[3])
+		taxid, err := strconv.Atoi(strings.TrimSpace(fields[4]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid taxon ID on line %d of %s", n, filename)
+		}
+		if code == "U" || taxid == 0 {
+			report.Unclassified += clade
+			continue
+		}
+		if rank == "" {
+			rank = ReportRank(code)
+		}
+		if _, ok := report.Tree.Taxids[taxid]; ok {
+			return nil, fmt.Errorf("taxon ID %d appears more than once in %s", taxid, filename)
+		}
+
+		name := strings.TrimLeft(fields[5], " ")
+		depth := (len(fields[5]) - len(name)) / 2
+		node := &Node{Taxid: taxid, Name: strings.TrimSpace(name), Rank: rank, Value: clade}
+		stack = stack[:min(depth, len(stack))]
+		if len(stack) > 0 {
+			node.Parent = stack[len(stack)-1]
+			node.Parent.Children = append(node.Parent.Children, node)
+		} else if report.Tree.Root == nil {
+			report.Tree.Root = node
+		} else {
+			return nil, fmt.Errorf("%s has more than one root taxon", filename)
+		}
+		stack = append(stack, node)
+
+		report.Tree.Taxids[taxid] = node
+		report.Direct[taxid] = direct
+		report.Codes[taxid] = code
+		if minimizers != nil {
+			if report.Minimizers == nil {
+				report.Minimizers = make(map[int]uint64)
+				report.DistinctMinimizers = make(map[int]uint64)
+			}
+			report.Minimizers[taxid], err = strconv.ParseUint(strings.TrimSpace(minimizers[0]), 10, 64)
+			if err == nil {
+				report.DistinctMinimizers[taxid], err = strconv.ParseUint(strings.TrimSpace(minimizers[1]), 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid minimizer count on line %d of %s", n, filename)
+			}
+		}
+		if kmers != nil {
+			if report.Kmers == nil {
+				report.Kmers = make(map[int]float64)
+				report.Duplication = make(map[int]float64)
+				report.Coverage = make(map[int]float64)
+			}
+			values := make([]float64, len(kmers))
+			for i, v := range kmers {
+				v = strings.TrimSpace(v)
+				if v == "NA" {
+					values[i] = math.NaN()
+				} else if values[i], err = strconv.ParseFloat(v, 64); err != nil {
+					return nil, fmt.Errorf("invalid k-mer statistics on line %d of %s", n, filename)
+				}
+			}
+			report.Kmers[taxid] = values[0]
+			report.Duplication[taxid] = values[1]
+			report.Coverage[taxid] = values[2]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// Clade returns the reads classified to a taxon or any of its descendants.
+func (r *Report) Clade(taxid int) float64 {
+	if node, ok := r.Tree.Taxids[taxid]; ok {
+		return node.Value
+	}
+	return 0
+}
+
+// Total returns the number of classified and unclassified reads.
+func (r *Report) Total() float64 {
+	total := r.Unclassified
+	if r.Tree.Root != nil {
+		total += r.Tree.Root.Value
+	}
+	return total
+}
+
+// Taxa returns the taxon IDs in the order of the report.
+func (r *Report) Taxa() []int {
+	taxa := make([]int, 0, len(r.Tree.Taxids))
+	var visit func(node *Node)
+	visit = func(node *Node) {
+		taxa = append(taxa, node.Taxid)
+		for _, c := range node.Children {
+			visit(c)
+		}
+	}
+	if r.Tree.Root != nil {
+		visit(r.Tree.Root)
+	}
+	return taxa
+}
@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+var named_report = filepath.Join("..", "testdata", "named_report.tsv")
+var minimizer_report = filepath.Join("..", "testdata", "minimizer_report.tsv")
+
+func TestReadReport(t *testing.T) {
+	for _, path := range []string{named_report, minimizer_report} {
+		if format, _ := GetFormat(path); format != "report" {
+			t.Errorf("Expected format report for %s but got %s.", path, format)
+		}
+		report, err := ReadReport(path)
+		if err != nil {
+			t.Fatalf("Could not read %s: %v", path, err)
+		}
+		if report.Tree.Root == nil || report.Tree.Root.Taxid != 1 {
+			t.Fatalf("Expected the root as root of %s.", path)
+		}
+		for taxid, node := range report.Tree.Taxids {
+			clade := report.Direct[taxid]
+			for _, c := range node.Children {
+				clade += c.Value
+			}
+			if clade != node.Value {
+				t.Errorf("Expected clade count %g for %d but got %g.", clade, taxid, node.Value)
+			}
+		}
+		if taxa := report.Taxa(); len(taxa) != len(report.Tree.Taxids) || taxa[0] != 1 {
+			t.Errorf("Expected all taxa starting with the root but got %v.", taxa)
+		}
+	}
+
+	report, _ := ReadReport(named_report)
+	if report.Unclassified != 481789 || report.Total() != 528969 {
+		t.Errorf("Expected 481789 unclassified of 528969 reads but got %g of %g.",
+			report.Unclassified, report.Total())
+	}
+	if report.Clade(134) != 44110 || report.Direct[134] != 11203 {
+		t.Errorf("Expected counts 44110 and 11203 for Streptococcus but got %g and %g.",
+			report.Clade(134), report.Direct[134])
+	}
+	if parent, _ := report.Tree.Parent(5539); parent != 134 {
+		t.Errorf("Expected parent %d but got %d.", 134, parent)
+	}
+	if report.Codes[59] != "R1" || report.Tree.Taxids[59].Rank != "no rank" {
+		t.Errorf("Expected Bacteria without rank but got %s.", report.Codes[59])
+	}
+	if report.Minimizers != nil {
+		t.Error("Expected no minimizer data.")
+	}
+
+	report, _ = ReadReport(minimizer_report)
+	if report.Minimizers[562] != 5400 || report.DistinctMinimizers[562] != 810 {
+		t.Errorf("Expected 5400 and 810 minimizers but got %d and %d.",
+			report.Minimizers[562], report.DistinctMinimizers[562])
+	}
+	if rank, _ := report.Tree.Rank(816); rank != "genus" {
+		t.Errorf("Expected rank genus but got %s.", rank)
+	}
+	if lca, _ := report.Tree.LCA(817, 821); lca != 815 {
+		t.Errorf("Expected LCA %d but got %d.", 815, lca)
+	}
+	if parent, _ := report.Tree.Parent(83333); parent != 562 {
+		t.Errorf("Expected parent %d but got %d.", 562, parent)
+	}
+}
+
+func TestKrakenUniq(t *testing.T) {
+	path := filepath.Join("..", "testdata", "krakenuniq_report.tsv")
+	if format, _ := GetFormat(path); format != "krakenuniq-report" {
+		t.Errorf("Expected format krakenuniq-report but got %s.", format)
+	}
+	report, err := ReadReport(path)
+	if err != nil {
+		t.Fatalf("Could not read %s: %v", path, err)
+	}
+	if report.Unclassified != 50 || report.Total() != 1000 {
+		t.Errorf("Expected 50 unclassified of 1000 reads but got %g of %g.",
+			report.Unclassified, report.Total())
+	}
+	if report.Kmers[1] != 2.21e6 || report.Duplication[817] != 1.25 || report.Coverage[562] != 0.088 {
+		t.Errorf("Unexpected k-mer statistics %g, %g and %g.",
+			report.Kmers[1], report.Duplication[817], report.Coverage[562])
+	}
+	if !math.IsNaN(report.Coverage[1224]) {
+		t.Errorf("Expected a missing coverage but got %g.", report.Coverage[1224])
+	}
+	if report.Tree.Taxids[83333].Rank != "strain" || report.Codes[562] != "S" || report.Codes[1] != "-" {
+		t.Errorf("Unexpected ranks %s, %s and %s.",
+			report.Tree.Taxids[83333].Rank, report.Codes[562], report.Codes[1])
+	}
+	if parent, _ := report.Tree.Parent(83333); parent != 562 {
+		t.Errorf("Expected parent %d but got %d.", 562, parent)
+	}
+
+	reads := filepath.Join("..", "testdata", "krakenuniq.kuniq")
+	if format, named := GetFormat(reads); format != "kraken2" || named {
+		t.Errorf("Expected format kraken2 for KrakenUniq output but got %s.", format)
+	}
+	k2map, err := SummarizeKmers(reads, false, nil)
+	if err != nil || k2map["817"].Classes["817"] != 86 || k2map["83333"].Classes["543"] != 22 {
+		t.Errorf("Could not summarize the k-mers of KrakenUniq output: %v", err)
+	}
+	scored := 0
+	_, err = scoreReads(reads, DetectTaxonomy(taxdump), MustParseFormat("{K};{p};{c};{o};{f};{g};{s}"), false,
+		func(line string, s *ReadScore) { scored++ })
+	if err != nil || scored != 3 {
+		t.Errorf("Expected 3 scored reads but got %d.", scored)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// SampleNames derives sample IDs from file names. Files listed in a sample
+// sheet use the ID from the sheet. Otherwise the ID is the first group matched
+// by the regular expression, or the whole match if it has no groups. Without
+// a regular expression it is the file name up to the first dot.
+type SampleNames struct {
+	Regex *regexp.Regexp
+	// Sample IDs for file paths from the sample sheet.
+	IDs map[string]string
+	// The metadata columns of the sample sheet and their values for each
+	// sample ID.
+	Columns  []string
+	Metadata map[string][]string
+}
+
+var sampleNames = &SampleNames{}
+
+// SetSampleNames sets how sample IDs are derived from file names.
+func SetSampleNames(names *SampleNames) {
+	sampleNames = names
+}
+
+// ReadSampleSheet reads a CSV file that maps file paths to sample IDs. It
+// needs a `sample_id` column and a `path` or `file` column. All other
+// columns are metadata. Paths and sample IDs may only appear once.
+func ReadSampleSheet(filename string) (*SampleNames, error) {
+	file, err := OpenFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the sample sheet %s: %w", filename, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("the sample sheet %s is empty", filename)
+	}
+
+	header := records[0]
+	id_idx := slices.Index(header, "sample_id")
+	path_idx := slices.Index(header, "path")
+	if path_idx < 0 {
+		path_idx = slices.Index(header, "file")
+	}
+	if id_idx < 0 || path_idx < 0 {
+		return nil, fmt.Errorf("the sample sheet %s needs a `sample_id` and a `path` column", filename)
+	}
+	names := &SampleNames{IDs: make(map[string]string), Metadata: make(map[string][]string)}
+	var meta []int
+	for i, col := range header {
+		if i != id_idx && i != path_idx {
+			names.Columns = append(names.Columns, col)
+			meta = append(meta, i)
+		}
+	}
+	for _, record := range records[1:] {
+		path, id := filepath.Clean(record[path_idx]), record[id_idx]
+		if _, ok := names.IDs[path]; ok {
+			return nil, fmt.Errorf("file %s appears more than once in the sample sheet", path)
+		}
+		if _, ok := names.Metadata[id]; ok {
+			return nil, fmt.Errorf("sample %s appears more than once in the sample sheet", id)
+		}
+		names.IDs[path] = id
+		values := make([]string, len(meta))
+		for j, i := range meta {
+			values[j] = record[i]
+		}
+		names.Metadata[id] = values
+	}
+	log.Printf("Read %d samples from %s.", len(names.IDs), filename)
+	return names, nil
+}
+
+// ID returns the sample ID for a file. Files in the sample sheet are matched
+// by their path or, if that is not found, by their file name.
+func (n *SampleNames) ID(path string) (string, error) {
+	if id, ok := n.IDs[filepath.Clean(path)]; ok {
+		return id, nil
+	}
+	if id, ok := n.IDs[filepath.Base(path)]; ok {
+		return id, nil
+	}
+	if n.Regex == nil {
+		return strings.Split(filepath.Base(path), ".")[0], nil
+	}
+	match := n.Regex.FindStringSubmatch(path)
+	switch {
+	case match == nil:
+		return "", fmt.Errorf("could not derive a sample ID from %s with `%s`", path, n.Regex)
+	case len(match) > 1:
+		return match[1], nil
+	}
+	return match[0], nil
+}
+
+// SampleID returns the sample ID for a file.
+func SampleID(path string) string {
+	id, err := sampleNames.ID(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return id
+}
+
+// SampleIDs returns the sample IDs for several files and fails if two files
+// have the same ID.
+func SampleIDs(paths []string) ([]string, error) {
+	ids := make([]string, len(paths))
+	seen := make(map[string]string)
+	for i, path := range paths {
+		ids[i] = SampleID(path)
+		if other, ok := seen[ids[i]]; ok {
+			return nil, fmt.Errorf("files %s and %s have the same sample ID %s", other, path, ids[i])
+		}
+		seen[ids[i]] = path
+	}
+	return ids, nil
+}
+
+// SampleColumns returns the metadata columns of the sample sheet.
+func SampleColumns() []string {
+	return sampleNames.Columns
+}
+
+// SampleMetadata returns the metadata of a sample. Samples missing from the
+// sample sheet have empty values.
+func SampleMetadata(sample_id string) []string {
+	if values, ok := sampleNames.Metadata[sample_id]; ok {
+		return values
+	}
+	return make([]string, len(sampleNames.Columns))
+}
@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestSampleNames(t *testing.T) {
+	defer SetSampleNames(&SampleNames{})
+
+	names := &SampleNames{}
+	for path, expected := range map[string]string{
+		"runs/S1.k2": "S1", "my.sample.b2": "my", StdStream: StdStream,
+	} {
+		if id, _ := names.ID(path); id != expected {
+			t.Errorf("Expected sample ID %s for %s but got %s.", expected, path, id)
+		}
+	}
+
+	names.Regex = regexp.MustCompile(`([^/]+)\.b2$`)
+	if id, _ := names.ID("runs/my.sample.b2"); id != "my.sample" {
+		t.Errorf("Expected sample ID my.sample but got %s.", id)
+	}
+	names.Regex = regexp.MustCompile(`S\d+`)
+	if id, _ := names.ID("runs/S12_R1.k2"); id != "S12" {
+		t.Errorf("Expected sample ID S12 but got %s.", id)
+	}
+	if _, err := names.ID("runs/other.k2"); err == nil {
+		t.Error("Expected an error for a file that does not match.")
+	}
+
+	dir := t.TempDir()
+	sheet := filepath.Join(dir, "samples.csv")
+	os.WriteFile(sheet, []byte("sample_id,path,group\nA,runs/S1.b2,case\nB,S2.b2,control\n"), 0644)
+	names, err := ReadSampleSheet(sheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := names.ID("./runs/S1.b2"); id != "A" {
+		t.Errorf("Expected sample ID A but got %s.", id)
+	}
+	if id, _ := names.ID("other/S2.b2"); id != "B" {
+		t.Errorf("Expected sample ID B but got %s.", id)
+	}
+	SetSampleNames(names)
+	if !slices.Equal(SampleColumns(), []string{"group"}) || SampleMetadata("B")[0] != "control" ||
+		SampleMetadata("C")[0] != "" {
+		t.Errorf("Unexpected metadata %v.", names.Metadata)
+	}
+	if _, err := SampleIDs([]string{"runs/S1.b2", "S1.b2"}); err != nil {
+		t.Errorf("Expected different sample IDs but got %v.", err)
+	}
+	if _, err := SampleIDs([]string{"runs/S1.b2", "./runs/S1.b2"}); err == nil {
+		t.Error("Expected an error for duplicate sample IDs.")
+	}
+
+	os.WriteFile(sheet, []byte("sample_id,path\nA,S1.b2\nA,S2.b2\n"), 0644)
+	if _, err := ReadSampleSheet(sheet); err == nil {
+		t.Error("Expected an error for duplicate samples in the sheet.")
+	}
+
+	SetSampleNames(&SampleNames{IDs: map[string]string{"test.b2": "T"}, Columns: []string{"group"},
+		Metadata: map[string][]string{"T": {"case"}}})
+	out := filepath.Join(dir, "merged.csv")
+	if err := MergeBracken([]string{filepath.Join("..", "testdata", "test.b2")}, out); err != nil {
+		t.Fatal(err)
+	}
+	file, _ := os.Open(out)
+	defer file.Close()
+	records, _ := csv.NewReader(file).ReadAll()
+	if records[0][len(records[0])-1] != "group" || records[1][0] != "T" || records[1][len(records[1])-1] != "case" {
+		t.Errorf("Expected sample T with metadata but got %v and %v.", records[0], records[1])
+	}
+	if format, _ := GetFormat(out); format != "bracken-merged" {
+		t.Errorf("Expected format bracken-merged but got %s.", format)
+	}
+}
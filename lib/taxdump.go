@@ -0,0 +1,179 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultDataDir returns the taxonomy location used when no data dir is given.
+// This follows taxonkit and uses `$TAXONKIT_DB` or `~/.taxonkit`.
+func DefaultDataDir() string {
+	if dir := os.Getenv("TAXONKIT_DB"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".taxonkit"
+	}
+	return filepath.Join(home, ".taxonkit")
+}
+
+// hasTaxdump checks whether a directory contains an NCBI taxonomy dump.
+func hasTaxdump(data_dir string) bool {
+	_, err := os.Stat(filepath.Join(data_dir, "nodes.dmp"))
+	return err == nil
+}
+
+// splitDmp splits a line from an NCBI dump file into its fields.
+func splitDmp(line string) []string {
+	return strings.Split(strings.TrimSuffix(line, "\t|"), "\t|\t")
+}
+
+// LoadTaxdump reads `nodes.dmp` and `names.dmp` from an NCBI taxonomy dump
+// and builds the full taxonomy tree. Merged and deleted taxon IDs are read
+// from `merged.dmp` and `delnodes.dmp` if present.
+func LoadTaxdump(data_dir string) (*Tree, error) {
+	if data_dir == "" {
+		data_dir = DefaultDataDir()
+	}
+	nodes, err := OpenFile(filepath.Join(data_dir, "nodes.dmp"))
+	if err != nil {
+		return nil, err
+	}
+	defer nodes.Close()
+
+	tree := &Tree{Taxids: make(map[int]*Node, 1e5)}
+	parents := make(map[int]int, 1e5)
+	scanner := bufio.NewScanner(nodes)
+	for scanner.Scan() {
+		fields := splitDmp(scanner.Text())
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("malformed line in nodes.dmp: %s", scanner.Text())
+		}
+		taxid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid taxon ID %s in nodes.dmp", fields[0])
+		}
+		parent, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid parent ID %s in nodes.dmp", fields[1])
+		}
+		tree.Taxids[taxid] = &Node{Taxid: taxid, Rank: fields[2]}
+		parents[taxid] = parent
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for taxid, parent := range parents {
+		node := tree.Taxids[taxid]
+		if taxid == parent {
+			tree.Root = node
+			continue
+		}
+		pnode, ok := tree.Taxids[parent]
+		if !ok {
+			return nil, fmt.Errorf("parent %d of taxon %d is not in nodes.dmp", parent, taxid)
+		}
+		node.Parent = pnode
+		pnode.Children = append(pnode.Children, node)
+	}
+
+	names, err := OpenFile(filepath.Join(data_dir, "names.dmp"))
+	if err != nil {
+		return nil, err
+	}
+	defer names.Close()
+
+	scanner = bufio.NewScanner(names)
+	for scanner.Scan() {
+		fields := splitDmp(scanner.Text())
+		if len(fields) < 4 || fields[3] != "scientific name" {
+			continue
+		}
+		taxid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid taxon ID %s in names.dmp", fields[0])
+		}
+		if node, ok := tree.Taxids[taxid]; ok {
+			node.Name = fields[1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	tree.Merged = make(map[int]int)
+	err = readOptionalDmp(filepath.Join(data_dir, "merged.dmp"), func(fields []string) error {
+		old, err := strconv.Atoi(fields[0])
+		if err != nil || len(fields) < 2 {
+			return fmt.Errorf("malformed entry %v in merged.dmp", fields)
+		}
+		newid, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return fmt.Errorf("malformed entry %v in merged.dmp", fields)
+		}
+		tree.Merged[old] = newid
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tree.Deleted = make(map[int]bool)
+	err = readOptionalDmp(filepath.Join(data_dir, "delnodes.dmp"), func(fields []string) error {
+		taxid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return fmt.Errorf("malformed entry %v in delnodes.dmp", fields)
+		}
+		tree.Deleted[taxid] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tree, nil
+}
+
+// readOptionalDmp calls `parse` for every line in a dump file that may be absent.
+func readOptionalDmp(path string, parse func([]string) error) error {
+	file, err := OpenFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if err := parse(splitDmp(scanner.Text())); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
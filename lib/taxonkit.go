@@ -0,0 +1,275 @@
+/*
+Copyright © 2023 Christian Diener <mail(a)cdiener.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TaxonkitProvider resolves taxa by calling an installed taxonkit. Lineages
+// and ancestors are looked up in one batch, which Subtree uses to build the
+// tree for scoring. Parent, Name and Rank start a taxonkit process for every
+// call.
+type TaxonkitProvider struct {
+	DataDir string
+}
+
+func HasTaxonkit() (string, bool) {
+	cmd := exec.Command("taxonkit", "version")
+	var out strings.Builder
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return "", false
+	}
+	version := strings.Split(out.String(), " v")[1]
+
+	return strings.Trim(version, "\r\n"), true
+}
+
+// run calls taxonkit with the given arguments and input.
+func (tk *TaxonkitProvider) run(input string, args ...string) (string, error) {
+	if tk.DataDir != "" {
+		args = append(args, "--data-dir", tk.DataDir)
+	}
+	cmd := exec.Command("taxonkit", args...)
+	cmd.Stdin = strings.NewReader(input)
+
+	var out strings.Builder
+	cmd.Stdout = &out
+	err := cmd.Run()
+	return out.String(), err
+}
+
+// Lineages obtains the lineages with `taxonkit reformat`. Merged taxon IDs
+// are resolved to their current ID first.
+func (tk *TaxonkitProvider) Lineages(taxids []string, format string) (map[string]*Lineage, error) {
+	f, err := ParseFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	ranks := f.Ranks
+	queries := make([]int, 0, len(taxids))
+	for _, taxid := range taxids {
+		if tid, err := strconv.Atoi(taxid); err == nil {
+			queries = append(queries, tid)
+		}
+	}
+	paths, err := tk.Ancestors(queries)
+	if err != nil {
+		return nil, err
+	}
+	var current []string
+	for _, path := range paths {
+		if path.Taxid > 0 {
+			current = append(current, strconv.Itoa(path.Taxid))
+		}
+	}
+
+	reformatted := make(map[string]*Lineage, len(current))
+	if len(ranks) > 0 && len(current) > 0 {
+		out, err := tk.run(strings.Join(current, "\n"), "reformat", "--taxid-field", "1",
+			"--show-lineage-taxids", "--add-prefix", "--format", f.RankFormat())
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(strings.Trim(out, "\r\n"), "\n") {
+			entries := strings.Split(line, "\t")
+			if len(entries) < 3 || entries[1] == "" {
+				continue
+			}
+			names := strings.Split(entries[1], ";")
+			tids := strings.Split(entries[2], ";")
+			if len(names) != len(ranks) || len(tids) != len(ranks) {
+				continue
+			}
+			present := make([]bool, len(tids))
+			for i, tid := range tids {
+				present[i] = tid != ""
+			}
+			reformatted[entries[0]] = &Lineage{Names: names, Taxids: tids,
+				Present: present, Filled: make([]bool, len(tids))}
+		}
+	}
+
+	results := make(map[string]*Lineage, len(taxids))
+	for _, taxid := range taxids {
+		tid, _ := strconv.Atoi(taxid)
+		path, ok := paths[tid]
+		if !ok {
+			results[taxid] = emptyLineage(ranks)
+			continue
+		}
+		current := strconv.Itoa(path.Taxid)
+		lin := emptyLineage(ranks)
+		if found, ok := reformatted[current]; ok {
+			lin = &Lineage{Names: slices.Clone(found.Names), Taxids: slices.Clone(found.Taxids),
+				Present: slices.Clone(found.Present), Filled: slices.Clone(found.Filled)}
+		}
+		if path.Taxid > 0 {
+			lin.Taxid = current
+		}
+		lin.Merged, lin.Deleted = path.Merged, path.Deleted
+		results[taxid] = lin
+	}
+
+	return results, nil
+}
+
+// Ancestors looks up the paths from the root to the taxa with a single
+// `taxonkit lineage` call. Unknown taxa are missing from the result.
+func (tk *TaxonkitProvider) Ancestors(taxids []int) (map[int]*AncestorPath, error) {
+	if len(taxids) == 0 {
+		return map[int]*AncestorPath{}, nil
+	}
+	queries := make([]string, len(taxids))
+	for i, taxid := range taxids {
+		queries[i] = strconv.Itoa(taxid)
+	}
+	out, err := tk.run(strings.Join(queries, "\n"), "lineage", "--show-status-code",
+		"--show-lineage-taxids", "--show-lineage-ranks")
+	if err != nil {
+		return nil, err
+	}
+	return parseTaxonkitLineages(out), nil
+}
+
+// parseTaxonkitLineages parses the output of `taxonkit lineage` with status
+// codes, lineage taxon IDs and lineage ranks. The status code is -1 for
+// unknown taxa, 0 for deleted taxa and the current taxon ID otherwise.
+// taxonkit does not report the root in lineages, so it is added here.
+func parseTaxonkitLineages(out string) map[int]*AncestorPath {
+	paths := make(map[int]*AncestorPath)
+	for _, line := range strings.Split(strings.Trim(out, "\r\n"), "\n") {
+		entries := strings.Split(strings.TrimRight(line, "\r"), "\t")
+		if len(entries) < 2 {
+			continue
+		}
+		query, err := strconv.Atoi(entries[0])
+		code, err2 := strconv.Atoi(entries[1])
+		if err != nil || err2 != nil || code < 0 {
+			continue
+		}
+		path := &AncestorPath{Taxid: code, Merged: code > 0 && code != query, Deleted: code == 0,
+			Taxids: []int{1}, Names: []string{"root"}, Ranks: []string{"no rank"}}
+		if code > 1 {
+			if len(entries) < 5 {
+				continue
+			}
+			names := strings.Split(entries[2], ";")
+			tids := strings.Split(entries[3], ";")
+			ranks := strings.Split(entries[4], ";")
+			if len(names) != len(tids) || len(ranks) != len(tids) {
+				continue
+			}
+			for i, tid := range tids {
+				taxid, err := strconv.Atoi(tid)
+				if err != nil {
+					path = nil
+					break
+				}
+				path.Taxids = append(path.Taxids, taxid)
+				path.Names = append(path.Names, names[i])
+				path.Ranks = append(path.Ranks, ranks[i])
+			}
+			if path == nil || path.Taxids[len(path.Taxids)-1] != code {
+				continue
+			}
+		}
+		if code == 0 {
+			path.Taxids, path.Names, path.Ranks = nil, nil, nil
+		}
+		paths[query] = path
+	}
+	return paths
+}
+
+// path returns the path from the root to a single taxon.
+func (tk *TaxonkitProvider) path(taxid int) (*AncestorPath, bool) {
+	paths, err := tk.Ancestors([]int{taxid})
+	if err != nil {
+		return nil, false
+	}
+	path, ok := paths[taxid]
+	if !ok || path.Taxid == 0 {
+		return nil, false
+	}
+	return path, true
+}
+
+func (tk *TaxonkitProvider) Parent(taxid int) (int, bool) {
+	path, ok := tk.path(taxid)
+	if !ok || len(path.Taxids) < 2 {
+		return 0, false
+	}
+	return path.Taxids[len(path.Taxids)-2], true
+}
+
+func (tk *TaxonkitProvider) Name(taxid int) (string, bool) {
+	path, ok := tk.path(taxid)
+	if !ok {
+		return "", false
+	}
+	return path.Names[len(path.Names)-1], true
+}
+
+func (tk *TaxonkitProvider) Rank(taxid int) (string, bool) {
+	path, ok := tk.path(taxid)
+	if !ok {
+		return "", false
+	}
+	return path.Ranks[len(path.Ranks)-1], true
+}
+
+// Children lists the direct children with `taxonkit list`.
+func (tk *TaxonkitProvider) Children(taxid int) []int {
+	out, err := tk.run("", "list", "--ids", strconv.Itoa(taxid), "--indent", " ")
+	if err != nil {
+		return nil
+	}
+	var children []int
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.HasPrefix(line, " ") || strings.HasPrefix(line, "  ") {
+			continue
+		}
+		if child, err := strconv.Atoi(strings.TrimSpace(line)); err == nil {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// TaxidsByName looks up taxon IDs with `taxonkit name2taxid`.
+func (tk *TaxonkitProvider) TaxidsByName(name string) []int {
+	out, err := tk.run(strings.TrimSpace(name), "name2taxid")
+	if err != nil {
+		return nil
+	}
+	var taxids []int
+	for _, line := range strings.Split(strings.Trim(out, "\r\n"), "\n") {
+		entries := strings.Split(line, "\t")
+		if len(entries) < 2 {
+			continue
+		}
+		if taxid, err := strconv.Atoi(entries[1]); err == nil {
+			taxids = append(taxids, taxid)
+		}
+	}
+	return taxids
+}
@@ -17,101 +17,298 @@ limitations under the License.
 package lib
 
 import (
+	"fmt"
 	"log"
-	"os/exec"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 type Lineage struct {
 	Names  []string
 	Taxids []string
+	// Present marks the ranks with an actual taxon in the lineage and Filled
+	// the missing ranks whose names were filled in from a higher rank.
+	Present []bool
+	Filled  []bool
+	// The current taxon ID. This differs from the queried ID if the taxon was
+	// merged and is empty if the taxon was deleted or is unknown.
+	Taxid   string
+	Merged  bool
+	Deleted bool
 }
 
 type Node struct {
 	Taxid    int
 	Name     string
+	Rank     string
 	Parent   *Node
 	Children []*Node
 	Value    float64
 }
 
 type Tree struct {
-	Root     *Node
-	Taxids   map[int]*Node
-	Children []*Node
+	Root   *Node
+	Taxids map[int]*Node
+	// Taxon IDs that were merged into another taxon and deleted taxon IDs.
+	Merged  map[int]int
+	Deleted map[int]bool
+
+	// Index for tree queries, built on first use.
+	idxOnce sync.Once
+	idx     *TaxonomyIndex
 }
 
-func HasTaxonkit() (string, bool) {
-	cmd := exec.Command("taxonkit", "version")
-	var out strings.Builder
-	cmd.Stdout = &out
-	if err := cmd.Run(); err != nil {
-		return "", false
+// RankSymbols maps the taxonkit format symbols to the NCBI ranks they match.
+var RankSymbols = map[string][]string{
+	"K": {"superkingdom", "domain"},
+	"d": {"domain", "superkingdom"},
+	"k": {"kingdom"},
+	"p": {"phylum"},
+	"c": {"class"},
+	"o": {"order"},
+	"f": {"family"},
+	"g": {"genus"},
+	"s": {"species"},
+	"t": {"subspecies", "strain"},
+	"S": {"subspecies"},
+	"T": {"strain"},
+}
+
+// RankPrefixes are the name prefixes used for each format symbol. Those are
+// the same as the defaults of `taxonkit reformat --add-prefix`.
+var RankPrefixes = map[string]string{
+	"K": "k__", "d": "d__", "k": "K__", "p": "p__", "c": "c__", "o": "o__", "f": "f__",
+	"g": "g__", "s": "s__", "t": "t__", "S": "S__", "T": "T__",
+}
+
+// FillPrefix is prepended to the names of filled-in ranks.
+const FillPrefix = "unclassified "
+
+// LineageFormat is a parsed taxonkit-style lineage format such as
+// `{K};{p};{c};{o};{f};{g};{s}`.
+type LineageFormat struct {
+	Format string
+	Ranks  []string
+	// The text before, between and after the rank symbols. This has one more
+	// entry than Ranks.
+	Separators []string
+	// Fill in missing ranks from the next higher rank.
+	FillMissing bool
+}
+
+var formatTerm = regexp.MustCompile(`{([^{}]*)}`)
+
+// ParseFormat parses a lineage format. Separators may be arbitrary text and
+// can contain `\t` for tabs.
+func ParseFormat(format string) (*LineageFormat, error) {
+	f := &LineageFormat{Format: format}
+	unescaped := strings.NewReplacer(`\t`, "\t", `\n`, "\n").Replace(format)
+	last := 0
+	for _, m := range formatTerm.FindAllStringSubmatchIndex(unescaped, -1) {
+		symbol := unescaped[m[2]:m[3]]
+		if _, ok := RankSymbols[symbol]; !ok {
+			return nil, fmt.Errorf("incorrect format term {%s} in `%s`", symbol, format)
+		}
+		f.Separators = append(f.Separators, unescaped[last:m[0]])
+		f.Ranks = append(f.Ranks, symbol)
+		last = m[1]
 	}
-	version := strings.Split(out.String(), " v")[1]
+	f.Separators = append(f.Separators, unescaped[last:])
+	return f, nil
+}
 
-	return strings.Trim(version, "\r\n"), true
+// MustParseFormat is like ParseFormat but exits on invalid formats.
+func MustParseFormat(format string) *LineageFormat {
+	f, err := ParseFormat(format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return f
 }
 
-func AddLineage[K any](taxids map[string]K, data_dir string, format string) map[string]*Lineage {
-	args := []string{"reformat", "--taxid-field", "1",
-		"--show-lineage-taxids", "--add-prefix"}
-	args = append(args, "--format", format)
-	if data_dir != "" {
-		args = append(args, "--data-dir", data_dir)
+// Join formats lineage entries, one for each rank of the format.
+func (f *LineageFormat) Join(entries []string) string {
+	var b strings.Builder
+	for i, e := range entries {
+		b.WriteString(f.Separators[i])
+		b.WriteString(e)
 	}
-	keys := make([]string, len(taxids))
-	i := 0
-	for k := range taxids {
-		keys[i] = k
-		i++
+	b.WriteString(f.Separators[len(entries)])
+	return b.String()
+}
+
+// RankFormat returns the format reduced to its ranks. Lineages only depend on
+// this so it is used to key taxonomy indices.
+func (f *LineageFormat) RankFormat() string {
+	if len(f.Ranks) == 0 {
+		return ""
 	}
+	return "{" + strings.Join(f.Ranks, "};{") + "}"
+}
+
+// emptyLineage returns a lineage with no assigned names on the given ranks.
+func emptyLineage(ranks []string) *Lineage {
+	lin := &Lineage{
+		Names:   make([]string, len(ranks)),
+		Taxids:  make([]string, len(ranks)),
+		Present: make([]bool, len(ranks)),
+		Filled:  make([]bool, len(ranks)),
+	}
+	for i, r := range ranks {
+		lin.Names[i] = RankPrefixes[r]
+	}
+	return lin
+}
 
-	cmd := exec.Command("taxonkit", args...)
-	cmd.Stdin = strings.NewReader(strings.Join(keys, "\n"))
+// Fill names missing ranks after the next higher rank that is present, like
+// `taxonkit reformat --fill-miss-rank`. For instance, a missing genus in the
+// Bacteroidaceae becomes `g__unclassified Bacteroidaceae`. Ranks above the
+// first present rank stay empty.
+func (lin *Lineage) Fill(ranks []string) {
+	higher := ""
+	for i, r := range ranks {
+		if lin.Present[i] {
+			higher = strings.TrimPrefix(lin.Names[i], RankPrefixes[r])
+			continue
+		}
+		if higher != "" {
+			lin.Names[i] = RankPrefixes[r] + FillPrefix + higher
+			lin.Filled[i] = true
+		}
+	}
+}
 
-	var out strings.Builder
-	cmd.Stdout = &out
-	err := cmd.Run()
+// Resolve maps a taxon ID onto the current taxonomy. Merged taxon IDs are
+// replaced by the ID they were merged into.
+func (t *Tree) Resolve(taxid int) (*Node, bool) {
+	if newid, ok := t.Merged[taxid]; ok {
+		taxid = newid
+	}
+	node, ok := t.Taxids[taxid]
+	return node, ok
+}
+
+// Lineage returns the lineage of a taxon on the given ranks. Ranks missing
+// from the lineage or unknown taxa yield entries with empty names.
+func (t *Tree) Lineage(taxid string, ranks []string) *Lineage {
+	lin := emptyLineage(ranks)
+	tid, err := strconv.Atoi(taxid)
 	if err != nil {
-		log.Fatal(err)
+		return lin
 	}
+	lin.Deleted = t.Deleted[tid]
+	_, lin.Merged = t.Merged[tid]
+	node, ok := t.Resolve(tid)
+	if !ok {
+		return lin
+	}
+	lin.Taxid = strconv.Itoa(node.Taxid)
+
+	for ; node != nil; node = node.Parent {
+		for i, r := range ranks {
+			if lin.Taxids[i] == "" && slices.Contains(RankSymbols[r], node.Rank) {
+				lin.Names[i] = RankPrefixes[r] + node.Name
+				lin.Taxids[i] = strconv.Itoa(node.Taxid)
+				lin.Present[i] = true
+			}
+		}
+	}
+
+	return lin
+}
 
+// Lineages returns the lineages of the taxon IDs on the ranks of the format.
+func (t *Tree) Lineages(taxids []string, format string) (map[string]*Lineage, error) {
+	ranks := GetRanks(format)
 	results := make(map[string]*Lineage, len(taxids))
+	for _, taxid := range taxids {
+		results[taxid] = t.Lineage(taxid, ranks)
+	}
+	return results, nil
+}
+
+// Parent returns the taxon ID of the parent.
+func (t *Tree) Parent(taxid int) (int, bool) {
+	node, ok := t.Resolve(taxid)
+	if !ok || node.Parent == nil {
+		return 0, false
+	}
+	return node.Parent.Taxid, true
+}
+
+// Name returns the scientific name of a taxon.
+func (t *Tree) Name(taxid int) (string, bool) {
+	node, ok := t.Resolve(taxid)
+	if !ok {
+		return "", false
+	}
+	return node.Name, true
+}
+
+// Rank returns the rank of a taxon.
+func (t *Tree) Rank(taxid int) (string, bool) {
+	node, ok := t.Resolve(taxid)
+	if !ok {
+		return "", false
+	}
+	return node.Rank, true
+}
+
+// Children returns the taxon IDs of the direct children of a taxon.
+func (t *Tree) Children(taxid int) []int {
+	node, ok := t.Resolve(taxid)
+	if !ok {
+		return nil
+	}
+	children := make([]int, len(node.Children))
+	for i, c := range node.Children {
+		children[i] = c.Taxid
+	}
+	return children
+}
+
+// AddLineage obtains lineages for a set of taxon IDs from a taxonomy. Missing
+// ranks are filled in if requested by the format.
+func AddLineage[K any](taxids map[string]K, taxonomy TaxonomyProvider, format *LineageFormat) map[string]*Lineage {
 	if len(taxids) == 0 {
 		log.Println("No taxids to classify.")
-		return results
+		return make(map[string]*Lineage)
 	}
 
-	for _, line := range strings.Split(strings.Trim(out.String(), "\r\n"), "\n") {
-		entries := strings.Split(line, "\t")
-		names := strings.Split(entries[1], ";")
-		tids := strings.Split(entries[2], ";")
-
-		results[entries[0]] = &Lineage{names, tids}
+	keys := make([]string, 0, len(taxids))
+	for k := range taxids {
+		keys = append(keys, k)
+	}
+	results, err := taxonomy.Lineages(keys, format.Format)
+	if err != nil {
+		log.Fatalf("could not read the taxonomy: %v", err)
+	}
+	if format.FillMissing {
+		for _, lin := range results {
+			lin.Fill(format.Ranks)
+		}
 	}
 
 	return results
 }
 
 func GetRanks(format string) []string {
-	re := regexp.MustCompile(`{(\w)}`)
-	var r []string
-	for _, match := range re.FindAllStringSubmatch(format, -1) {
-		if match[1] == "" {
-			log.Fatalf("Incorrect format term %s.", match[0])
-		}
-		r = append(r, match[1])
-	}
-	return r
+	return MustParseFormat(format).Ranks
 }
 
+// GetLeaf returns the index and name of the lowest rank that is present in a
+// lineage. Filled-in ranks are ignored.
 func GetLeaf(lin *Lineage) (int, string) {
 	leaf := ""
 	idx := -1
+	if lin == nil {
+		return idx, leaf
+	}
 	for i := len(lin.Names) - 1; i >= 0; i-- {
-		if len(lin.Names[i]) > 3 {
+		if lin.Present[i] {
 			idx = i
 			leaf = lin.Names[i]
 			break
@@ -119,3 +316,33 @@ func GetLeaf(lin *Lineage) (int, string) {
 	}
 	return idx, leaf
 }
+
+// RemapSummary counts records whose taxon IDs were merged or deleted in the
+// taxonomy.
+type RemapSummary struct {
+	Merged  int
+	Deleted int
+}
+
+// Count adds `n` records classified with the given lineage.
+func (r *RemapSummary) Count(lin *Lineage, n int) {
+	if lin == nil {
+		return
+	}
+	if lin.Merged {
+		r.Merged += n
+	}
+	if lin.Deleted {
+		r.Deleted += n
+	}
+}
+
+// Log reports the number of affected records if there are any.
+func (r *RemapSummary) Log(unit string) {
+	if r.Merged > 0 {
+		log.Printf("%d %s had merged taxon IDs and were remapped.", r.Merged, unit)
+	}
+	if r.Deleted > 0 {
+		log.Printf("%d %s had deleted taxon IDs and have no lineage.", r.Deleted, unit)
+	}
+}
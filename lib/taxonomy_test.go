@@ -0,0 +1,177 @@
+package lib
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var taxdump = filepath.Join("..", "testdata", "taxdump")
+
+func TestLoadTaxdump(t *testing.T) {
+	tree, err := LoadTaxdump(taxdump)
+	if err != nil {
+		t.Fatalf("Could not read the taxonomy dump: %v", err)
+	}
+	if tree.Root == nil || tree.Root.Taxid != 1 {
+		t.Fatal("Taxonomy has no root.")
+	}
+	node, ok := tree.Taxids[821]
+	if !ok {
+		t.Fatal("Expected taxon 821 in the taxonomy.")
+	}
+	if node.Name != "Phocaeicola vulgatus" || node.Rank != "species" {
+		t.Errorf("Got wrong name or rank for 821: %s (%s).", node.Name, node.Rank)
+	}
+	if node.Parent.Taxid != 909656 {
+		t.Errorf("Expected parent %d but got %d.", 909656, node.Parent.Taxid)
+	}
+}
+
+func TestAddLineage(t *testing.T) {
+	taxids := map[string]bool{"821": true, "815": true, "0": true}
+	lineages := AddLineage(taxids, DetectTaxonomy(taxdump), MustParseFormat("{K};{p};{c};{o};{f};{g};{s}"))
+	if len(lineages) != 3 {
+		t.Fatalf("Expected 3 lineages but got %d.", len(lineages))
+	}
+
+	names := strings.Join(lineages["821"].Names, ";")
+	expected := "k__Bacteria;p__Bacteroidota;c__Bacteroidia;o__Bacteroidales;" +
+		"f__Bacteroidaceae;g__Phocaeicola;s__Phocaeicola vulgatus"
+	if names != expected {
+		t.Errorf("Expected lineage %s but got %s.", expected, names)
+	}
+	tids := strings.Join(lineages["821"].Taxids, ";")
+	if tids != "2;976;200643;171549;815;909656;821" {
+		t.Errorf("Got wrong taxid lineage %s.", tids)
+	}
+
+	idx, leaf := GetLeaf(lineages["815"])
+	if idx != 4 || leaf != "f__Bacteroidaceae" {
+		t.Errorf("Expected leaf %s but got %s.", "f__Bacteroidaceae", leaf)
+	}
+	if idx, _ := GetLeaf(lineages["0"]); idx != -1 {
+		t.Errorf("Unknown taxa should not have a leaf but got index %d.", idx)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	format := MustParseFormat(`{K}\t{g} {s}|`)
+	if strings.Join(format.Ranks, "") != "Kgs" {
+		t.Errorf("Expected ranks %s but got %v.", "Kgs", format.Ranks)
+	}
+	joined := format.Join([]string{"a", "b", "c"})
+	if joined != "a\tb c|" {
+		t.Errorf("Expected %q but got %q.", "a\tb c|", joined)
+	}
+	if format.RankFormat() != "{K};{g};{s}" {
+		t.Errorf("Expected %s but got %s.", "{K};{g};{s}", format.RankFormat())
+	}
+	if _, err := ParseFormat("{p};{x}"); err == nil {
+		t.Error("Expected an error for an unknown rank symbol.")
+	}
+}
+
+func TestFillMissing(t *testing.T) {
+	format := MustParseFormat("{K};{f};{g};{s}")
+	format.FillMissing = true
+	taxids := map[string]bool{"815": true, "0": true}
+	lineages := AddLineage(taxids, DetectTaxonomy(taxdump), format)
+
+	lin := lineages["815"]
+	expected := "k__Bacteria;f__Bacteroidaceae;g__unclassified Bacteroidaceae;" +
+		"s__unclassified Bacteroidaceae"
+	if format.Join(lin.Names) != expected {
+		t.Errorf("Expected lineage %s but got %s.", expected, format.Join(lin.Names))
+	}
+	if !lin.Filled[2] || lin.Present[2] || lin.Taxids[2] != "" {
+		t.Errorf("Expected the genus to be filled but got %v.", lin)
+	}
+	if idx, leaf := GetLeaf(lin); idx != 1 || leaf != "f__Bacteroidaceae" {
+		t.Errorf("Expected leaf %s but got %s.", "f__Bacteroidaceae", leaf)
+	}
+	if format.Join(lineages["0"].Names) != "k__;f__;g__;s__" {
+		t.Errorf("Unknown taxa should not be filled but got %v.", lineages["0"].Names)
+	}
+}
+
+func TestKrakenTaxonomy(t *testing.T) {
+	k2db := filepath.Join("..", "testdata", "k2db")
+	tree, err := LoadTaxonomy(k2db)
+	if err != nil {
+		t.Fatalf("Could not read the Kraken2 taxonomy: %v", err)
+	}
+	ref, _ := LoadTaxdump(taxdump)
+	if len(tree.Taxids) != len(ref.Taxids) {
+		t.Errorf("Expected %d taxa but got %d.", len(ref.Taxids), len(tree.Taxids))
+	}
+	if tree.Root == nil || tree.Root.Taxid != 1 {
+		t.Fatal("Taxonomy has no root.")
+	}
+
+	ranks := GetRanks("{K};{p};{c};{o};{f};{g};{s};{T}")
+	for _, taxid := range []string{"821", "83333", "2005525"} {
+		got := strings.Join(tree.Lineage(taxid, ranks).Names, ";")
+		expected := strings.Join(ref.Lineage(taxid, ranks).Names, ";")
+		if got != expected {
+			t.Errorf("Expected lineage %s but got %s.", expected, got)
+		}
+	}
+}
+
+func TestMergedAndDeleted(t *testing.T) {
+	taxids := map[string]bool{"1000001": true, "1000003": true, "821": true}
+	lineages := AddLineage(taxids, DetectTaxonomy(taxdump), MustParseFormat("{K};{p};{c};{o};{f};{g};{s}"))
+
+	merged := lineages["1000001"]
+	if !merged.Merged || merged.Taxid != "821" {
+		t.Errorf("Expected 1000001 to be merged into 821 but got %s.", merged.Taxid)
+	}
+	if merged.Names[6] != "s__Phocaeicola vulgatus" {
+		t.Errorf("Merged taxon has the wrong lineage %v.", merged.Names)
+	}
+	deleted := lineages["1000003"]
+	if !deleted.Deleted || deleted.Taxid != "" {
+		t.Error("Expected 1000003 to be deleted.")
+	}
+	if idx, _ := GetLeaf(deleted); idx != -1 {
+		t.Error("Deleted taxa should not have a leaf.")
+	}
+
+	var summary RemapSummary
+	for _, lin := range lineages {
+		summary.Count(lin, 2)
+	}
+	if summary.Merged != 2 || summary.Deleted != 2 {
+		t.Errorf("Expected 2 merged and 2 deleted reads but got %d and %d.",
+			summary.Merged, summary.Deleted)
+	}
+}
+
+func TestGTDB(t *testing.T) {
+	gtdb := filepath.Join("..", "testdata", "gtdb")
+	if !IsGTDB(gtdb) {
+		t.Fatal("Expected a GTDB taxonomy.")
+	}
+	tree, err := LoadTaxonomy(gtdb)
+	if err != nil {
+		t.Fatalf("Could not read the GTDB taxonomy: %v", err)
+	}
+	if len(tree.Taxids) != 19 {
+		t.Errorf("Expected %d taxa but got %d.", 19, len(tree.Taxids))
+	}
+
+	lin := tree.Lineage("5539", GetRanks(GTDBFormat))
+	expected := "d__Bacteria;p__Firmicutes;c__Bacilli;o__Lactobacillales;" +
+		"f__Streptococcaceae;g__Streptococcus;s__Streptococcus thermophilus"
+	if strings.Join(lin.Names, ";") != expected {
+		t.Errorf("Expected lineage %s but got %s.", expected, strings.Join(lin.Names, ";"))
+	}
+	if strings.Join(lin.Taxids, ";") != "59;82;83;88;133;134;5539" {
+		t.Errorf("Got wrong taxid lineage %v.", lin.Taxids)
+	}
+	arch := tree.Lineage("8", GetRanks(GTDBFormat))
+	if arch.Names[0] != "d__Archaea" || arch.Names[5] != "g__Methanobrevibacter_A" {
+		t.Errorf("Got wrong archaeal lineage %v.", arch.Names)
+	}
+}
@@ -19,17 +19,17 @@ import (
 	"bytes"
 	"io"
 	"log"
-	"os"
 )
 
 // Count the number of lines in a file
 func CountLines(path string) (int, error) {
 	buf := make([]byte, 64*1024)
 	sep := []byte{'\n'}
-	reader, err := os.Open(path)
+	reader, err := OpenFile(path)
 	if err != nil {
 		log.Fatalf("Could not open the file %s.", path)
 	}
+	defer reader.Close()
 
 	count := 0
 	for {